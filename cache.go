@@ -1,18 +1,107 @@
 package dnsr
 
 import (
+	"container/list"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/miekg/dns"
 )
 
 type cache struct {
 	capacity int
 	expire   bool
 	m        sync.RWMutex
-	entries  map[string]entry
+	entries  map[string]*entry
+	pinned   map[string]int      // reference counts of pinned names
+	pins     map[string][]string // names pinned per key
+
+	// lru orders entries by last use, most recent first. Readers holding
+	// the read lock also hold lruMu to move entries; writers holding the
+	// write lock need not.
+	lru   *list.List // of string
+	lruMu sync.Mutex
+
+	evictions uint64         // entries evicted to make room
+	recent    evictionWindow // evictions within the last EvictionRateWindow
+
+	onEvict func(qname string, reason EvictReason) // called for each eviction, if set
+	evicted []eviction                             // evictions not yet passed to onEvict
+}
+
+// EvictReason describes why a cache entry was evicted.
+type EvictReason int
+
+const (
+	EvictExpired  EvictReason = iota // all records of the entry expired
+	EvictCapacity                    // the cache was full
+)
+
+func (reason EvictReason) String() string {
+	switch reason {
+	case EvictExpired:
+		return "expired"
+	case EvictCapacity:
+		return "capacity"
+	}
+	return "unknown"
 }
 
-type entry map[RR]struct{}
+type eviction struct {
+	qname  string
+	reason EvictReason
+}
+
+// entry holds the cached records for a name.
+// For NXDOMAIN responses, the entry is present, but has no records or NODATA types.
+// Zero expiry times never expire.
+type entry struct {
+	rrs      map[rrKey]RR
+	nodata   map[string]time.Time // expiry of types with no records (NODATA)
+	nxExpiry time.Time            // expiry of an NXDOMAIN response
+	raw      map[rrKey]dns.RR     // records rrs were converted from, if retained
+	rotation atomic.Uint64        // lookups of the entry, for round-robin ordering
+	elem     *list.Element        // position in the LRU list
+}
+
+// rrKey identifies a cached record, independent of its TTL and expiry,
+// so a record received again replaces the cached copy.
+type rrKey struct {
+	Name     string
+	Type     string
+	Value    string
+	Segments string // lengths of the strings of a multi-string TXT record
+}
+
+// newRRKey returns the key of rr. If drr, the record rr was converted from,
+// is a TXT record of more than one string, the key includes the lengths of
+// its strings, so records with the same Value but different strings,
+// e.g. "ab" "c" and "a" "bc", are cached separately.
+func newRRKey(rr RR, drr dns.RR) rrKey {
+	k := rrKey{Name: rr.Name, Type: rr.Type, Value: rr.Value}
+	if txt, ok := drr.(*dns.TXT); ok && len(txt.Txt) > 1 {
+		lens := make([]string, len(txt.Txt))
+		for i, s := range txt.Txt {
+			lens[i] = strconv.Itoa(len(s))
+		}
+		k.Segments = strings.Join(lens, ",")
+	}
+	return k
+}
+
+// nx reports whether e represents an NXDOMAIN response.
+func (e *entry) nx() bool {
+	return e.rrs == nil && e.nodata == nil
+}
+
+// expired reports whether expiry, the expiry time of a cached record
+// or negative response, is before now.
+func expired(expiry, now time.Time) bool {
+	return !expiry.IsZero() && expiry.Before(now)
+}
 
 const MinCacheCapacity = 1000
 
@@ -24,57 +113,182 @@ func newCache(capacity int, expire bool) *cache {
 	}
 	return &cache{
 		capacity: capacity,
-		entries:  make(map[string]entry),
+		entries:  make(map[string]*entry),
 		expire:   expire,
+		pinned:   make(map[string]int),
+		pins:     make(map[string][]string),
+		lru:      list.New(),
+	}
+}
+
+// setCapacity changes the capacity of c, evicting entries if c is over capacity.
+// Capacity defaults to MinCacheCapacity if <= 0.
+// Safe for concurrent usage.
+func (c *cache) setCapacity(capacity int) {
+	if capacity <= 0 {
+		capacity = MinCacheCapacity
+	}
+	defer c.notifyEvicted()
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.capacity = capacity
+	c._evictTo(capacity)
+}
+
+// pin protects the entries for names from eviction and expiry
+// until unpin is called with the same key. Pinning a key again
+// replaces the names previously pinned for it.
+// Safe for concurrent usage.
+func (c *cache) pin(key string, names []string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c._unpin(key)
+	for _, name := range names {
+		c.pinned[name]++
 	}
+	c.pins[key] = names
+}
+
+// unpin releases the names pinned for key.
+// Safe for concurrent usage.
+func (c *cache) unpin(key string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c._unpin(key)
+}
+
+// _unpin does NOT lock the mutex so unsafe for concurrent usage.
+func (c *cache) _unpin(key string) {
+	for _, name := range c.pins[key] {
+		if c.pinned[name]--; c.pinned[name] <= 0 {
+			delete(c.pinned, name)
+		}
+	}
+	delete(c.pins, key)
 }
 
 // add adds 0 or more DNS records to the resolver cache for a specific
 // domain name and record type. This ensures the cache entry exists, even
 // if empty, for NXDOMAIN responses.
 func (c *cache) add(qname string, rr RR) {
+	defer c.notifyEvicted()
 	c.m.Lock()
 	defer c.m.Unlock()
-	c._add(qname, rr)
+	c._add(qname, rr, nil, false)
 }
 
-// addNX adds an NXDOMAIN to the cache.
+// addRR is like add, for rr converted from drr. If retain is true, drr is
+// retained for ResolveRaw.
 // Safe for concurrent usage.
-func (c *cache) addNX(qname string) {
+func (c *cache) addRR(qname string, rr RR, drr dns.RR, retain bool) {
+	defer c.notifyEvicted()
 	c.m.Lock()
 	defer c.m.Unlock()
-	c._addEntry(qname)
+	c._add(qname, rr, drr, retain)
+}
+
+// addNX adds an NXDOMAIN to the cache, expiring at expiry.
+// Safe for concurrent usage.
+func (c *cache) addNX(qname string, expiry time.Time) {
+	defer c.notifyEvicted()
+	c.m.Lock()
+	defer c.m.Unlock()
+	e := c._addEntry(qname)
+	e.nxExpiry = expiry
+}
+
+// addNoData records that qname has no records of type qtype (NODATA),
+// expiring at expiry.
+// Safe for concurrent usage.
+func (c *cache) addNoData(qname, qtype string, expiry time.Time) {
+	defer c.notifyEvicted()
+	c.m.Lock()
+	defer c.m.Unlock()
+	e := c._addEntry(qname)
+	if e.nodata == nil {
+		e.nodata = make(map[string]time.Time)
+	}
+	e.nodata[qtype] = expiry
 }
 
 // _add does NOT lock the mutex so unsafe for concurrent usage.
-func (c *cache) _add(qname string, rr RR) {
-	e, ok := c.entries[qname]
+func (c *cache) _add(qname string, rr RR, drr dns.RR, retain bool) {
+	e := c._addEntry(qname)
+	if e.rrs == nil {
+		e.rrs = make(map[rrKey]RR)
+	}
+	k := newRRKey(rr, drr)
+	// Don’t demote an authoritative record when it is seen again as glue
+	if old, ok := e.rrs[k]; ok && old.Authoritative {
+		rr.Authoritative = true
+	}
+	e.rrs[k] = rr
+	if retain {
+		if e.raw == nil {
+			e.raw = make(map[rrKey]dns.RR)
+		}
+		e.raw[k] = drr
+	} else {
+		delete(e.raw, k)
+	}
+}
+
+// getRaw returns copies of the retained records rr may have been converted
+// from: more than one if TXT records differ only in their strings.
+// Safe for concurrent usage.
+func (c *cache) getRaw(rr RR) []dns.RR {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	e, ok := c.entries[rr.Name]
 	if !ok {
-		c._evict()
+		return nil
 	}
-	if e == nil {
-		c.entries[qname] = make(map[RR]struct{})
-		e = c.entries[qname]
+	var drrs []dns.RR
+	for k, drr := range e.raw {
+		if k.Name == rr.Name && k.Type == rr.Type && k.Value == rr.Value {
+			drrs = append(drrs, dns.Copy(drr))
+		}
 	}
-	e[rr] = struct{}{}
+	return drrs
 }
 
-// _addEntry adds an entry for qname to c.
+// _addEntry adds an entry for qname to c if not present, and returns it
+// as the most recently used entry.
 // Not safe for concurrent usage.
-func (c *cache) _addEntry(qname string) {
-	_, ok := c.entries[qname]
+func (c *cache) _addEntry(qname string) *entry {
+	e, ok := c.entries[qname]
 	if !ok {
 		c._evict()
 		// For NXDOMAIN responses,
-		// the cache entry is present, but nil.
-		c.entries[qname] = nil
+		// the cache entry is present, but empty.
+		e = &entry{elem: c.lru.PushFront(qname)}
+		c.entries[qname] = e
+	} else {
+		c.lru.MoveToFront(e.elem)
 	}
+	return e
 }
 
-// FIXME: better random cache eviction than Go’s random key guarantee?
+// touch marks e as the most recently used entry.
+// The caller must hold the read lock.
+func (c *cache) touch(e *entry) {
+	c.lruMu.Lock()
+	c.lru.MoveToFront(e.elem)
+	c.lruMu.Unlock()
+}
+
+// _evict evicts an entry to make room for another.
 // Not safe for concurrent usage.
 func (c *cache) _evict() {
-	if len(c.entries) < c.capacity {
+	c._evictTo(c.capacity - 1)
+}
+
+// _evictTo evicts entries until c has at most n entries, other than pinned entries.
+// Expired entries are evicted first, then the least recently used,
+// preferring names of more than 2 labels.
+// Not safe for concurrent usage.
+func (c *cache) _evictTo(n int) {
+	if len(c.entries) <= n {
 		return
 	}
 
@@ -82,56 +296,210 @@ func (c *cache) _evict() {
 	if c.expire {
 		now := time.Now()
 		for k, e := range c.entries {
-			for rr := range e {
-				if !rr.Expiry.IsZero() && rr.Expiry.Before(now) {
-					delete(e, rr)
+			if c.pinned[k] > 0 {
+				continue
+			}
+			if e.nx() {
+				if expired(e.nxExpiry, now) {
+					c._delete(k, EvictExpired)
+				}
+			} else {
+				for k, rr := range e.rrs {
+					if expired(rr.Expiry, now) {
+						delete(e.rrs, k)
+						delete(e.raw, k)
+					}
+				}
+				for qtype, expiry := range e.nodata {
+					if expired(expiry, now) {
+						delete(e.nodata, qtype)
+					}
+				}
+				if len(e.rrs) == 0 && len(e.nodata) == 0 {
+					c._delete(k, EvictExpired)
 				}
 			}
-			if len(e) == 0 {
-				delete(c.entries, k)
+			if len(c.entries) <= n {
+				return
 			}
-			if len(c.entries) < c.capacity {
+		}
+	}
+
+	// Then evict the least recently used entries, keeping entries for names
+	// of 1 or 2 labels, such as TLD delegations, until no others remain
+	for _, short := range []bool{false, true} {
+		for el := c.lru.Back(); el != nil; {
+			k := el.Value.(string)
+			el = el.Prev()
+			if c.pinned[k] > 0 || (!short && dns.CountLabel(k) <= 2) {
+				continue
+			}
+			c._delete(k, EvictCapacity)
+			if len(c.entries) <= n {
 				return
 			}
 		}
 	}
+}
 
-	// Then randomly evict entries
-	for k := range c.entries {
-		delete(c.entries, k)
-		if len(c.entries) < c.capacity {
-			return
+// remove removes the entry for qname, if any, without counting an eviction.
+// Safe for concurrent usage.
+func (c *cache) remove(qname string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if e, ok := c.entries[qname]; ok {
+		c.lru.Remove(e.elem)
+		delete(c.entries, qname)
+	}
+}
+
+// _delete evicts the entry for qname for reason.
+// Not safe for concurrent usage.
+func (c *cache) _delete(qname string, reason EvictReason) {
+	if e, ok := c.entries[qname]; ok {
+		c.lru.Remove(e.elem)
+	}
+	delete(c.entries, qname)
+	c.evictions++
+	c.recent.add(time.Now())
+	if c.onEvict != nil {
+		c.evicted = append(c.evicted, eviction{qname, reason})
+	}
+}
+
+// notifyEvicted passes pending evictions to onEvict, if set.
+// It must be called without holding the lock.
+func (c *cache) notifyEvicted() {
+	if c.onEvict == nil {
+		return
+	}
+	c.m.Lock()
+	evicted := c.evicted
+	c.evicted = nil
+	c.m.Unlock()
+	for _, ev := range evicted {
+		c.onEvict(ev.qname, ev.reason)
+	}
+}
+
+// evictionCount returns the number of entries evicted from c,
+// and the number per second within the last EvictionRateWindow.
+// Safe for concurrent usage.
+func (c *cache) evictionCount() (uint64, float64) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	return c.evictions, c.recent.rate(time.Now())
+}
+
+// stats returns the capacity and current contents of c.
+// Safe for concurrent usage.
+func (c *cache) stats() CacheStats {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	s := CacheStats{Capacity: c.capacity, Len: len(c.entries)}
+	for _, e := range c.entries {
+		s.RecordCount += len(e.rrs)
+		if e.nx() {
+			s.NXCount++
 		}
 	}
+	return s
 }
 
 // get returns a randomly ordered slice of DNS records.
 func (c *cache) get(qname string) RRs {
+	return c.getAt(qname, time.Now())
+}
+
+// getAt returns a randomly ordered slice of DNS records
+// that have not expired at time now.
+func (c *cache) getAt(qname string, now time.Time) RRs {
 	c.m.RLock()
 	defer c.m.RUnlock()
 	e, ok := c.entries[qname]
 	if !ok {
 		return nil
 	}
-	if len(e) == 0 {
+	c.touch(e)
+	if e.nx() {
+		if c.expire && c.pinned[qname] == 0 && expired(e.nxExpiry, now) {
+			return nil // expired, so unknown
+		}
 		return emptyRRs
 	}
-	if c.expire {
-		now := time.Now()
-		rrs := make(RRs, 0, len(e))
-		for rr := range e {
+	if len(e.rrs) == 0 {
+		return nil
+	}
+	if c.expire && c.pinned[qname] == 0 {
+		rrs := make(RRs, 0, len(e.rrs))
+		for _, rr := range e.rrs {
 			if rr.Expiry.IsZero() || rr.Expiry.After(now) {
 				rrs = append(rrs, rr)
 			}
 		}
+		if len(rrs) == 0 {
+			return nil // expired, which isn’t NXDOMAIN
+		}
 		return rrs
 	} else {
 		i := 0
-		rrs := make(RRs, len(e))
-		for rr := range e {
+		rrs := make(RRs, len(e.rrs))
+		for _, rr := range e.rrs {
 			rrs[i] = rr
 			i++
 		}
 		return rrs
 	}
 }
+
+// rangeEntries calls fn for each cached name and its records, in random order,
+// until fn returns false. NXDOMAIN entries are passed as empty RRs.
+// Names with no cached records otherwise are skipped.
+// The cache is read-locked while fn is called.
+func (c *cache) rangeEntries(fn func(qname string, rrs RRs) bool) {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	for qname, e := range c.entries {
+		var rrs RRs
+		if e.nx() {
+			rrs = RRs{}
+		} else if len(e.rrs) == 0 {
+			continue
+		} else {
+			rrs = make(RRs, 0, len(e.rrs))
+			for _, rr := range e.rrs {
+				rrs = append(rrs, rr)
+			}
+		}
+		if !fn(qname, rrs) {
+			return
+		}
+	}
+}
+
+// hasNoData reports whether qname is cached as having no records of type qtype.
+func (c *cache) hasNoData(qname, qtype string) bool {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	e, ok := c.entries[qname]
+	if !ok {
+		return false
+	}
+	expiry, ok := e.nodata[qtype]
+	if ok && c.expire && c.pinned[qname] == 0 {
+		return !expired(expiry, time.Now())
+	}
+	return ok
+}
+
+// rotate returns the number of times rotate has been called for qname,
+// or 0 if qname is not cached.
+func (c *cache) rotate(qname string) uint64 {
+	c.m.RLock()
+	defer c.m.RUnlock()
+	e, ok := c.entries[qname]
+	if !ok {
+		return 0
+	}
+	return e.rotation.Add(1) - 1
+}
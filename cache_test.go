@@ -1,6 +1,7 @@
 package dnsr
 
 import (
+	"fmt"
 	"sync"
 	"testing"
 	"time"
@@ -10,16 +11,31 @@ import (
 
 func TestCache(t *testing.T) {
 	c := newCache(100, false)
-	c.addNX("hello.")
+	c.addNX("hello.", time.Time{})
 	rr := RR{Name: "hello.", Type: "A", Value: "1.2.3.4"}
 	c.add("hello.", rr)
 	rrs := c.get("hello.")
 	st.Expect(t, len(rrs), 1)
 }
 
+func TestCacheNoData(t *testing.T) {
+	c := newCache(100, false)
+	c.addNoData("hello.", "MX", time.Time{})
+	st.Expect(t, c.get("hello."), RRs(nil))
+	st.Expect(t, c.hasNoData("hello.", "MX"), true)
+	st.Expect(t, c.hasNoData("hello.", "A"), false)
+	rr := RR{Name: "hello.", Type: "A", Value: "1.2.3.4"}
+	c.add("hello.", rr)
+	st.Expect(t, c.get("hello."), RRs{rr})
+	st.Expect(t, c.hasNoData("hello.", "MX"), true)
+	c.addNX("world.", time.Time{})
+	st.Expect(t, c.get("world."), emptyRRs)
+	st.Expect(t, c.hasNoData("world.", "MX"), false)
+}
+
 func TestLiveCacheEntry(t *testing.T) {
 	c := newCache(100, true)
-	c.addNX("alive.")
+	c.addNX("alive.", time.Time{})
 	alive := time.Now().Add(time.Minute)
 	rr := RR{Name: "alive.", Type: "A", Value: "1.2.3.4", Expiry: alive}
 	c.add("alive.", rr)
@@ -29,12 +45,13 @@ func TestLiveCacheEntry(t *testing.T) {
 
 func TestExpiredCacheEntry(t *testing.T) {
 	c := newCache(100, true)
-	c.addNX("expired.")
+	c.addNX("expired.", time.Time{})
 	expired := time.Now().Add(-time.Minute)
 	rr := RR{Name: "expired.", Type: "A", Value: "1.2.3.4", Expiry: expired}
 	c.add("expired.", rr)
 	rrs := c.get("expired.")
 	st.Expect(t, len(rrs), 0)
+	st.Expect(t, rrs, RRs(nil))
 }
 
 func TestCacheContention(t *testing.T) {
@@ -44,7 +61,7 @@ func TestCacheContention(t *testing.T) {
 	f := func() {
 		rrs := c.get(k)
 		st.Expect(t, len(rrs), 0)
-		c.addNX(k)
+		c.addNX(k, time.Time{})
 		expired := time.Now().Add(-time.Minute)
 		rr := RR{Name: k, Type: "A", Value: "1.2.3.4", Expiry: expired}
 		c.add(k, rr)
@@ -56,3 +73,130 @@ func TestCacheContention(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+func TestCachePin(t *testing.T) {
+	c := newCache(2, true)
+	expired := time.Now().Add(-time.Minute)
+	rr := RR{Name: "pinned.", Type: "A", Value: "1.2.3.4", Expiry: expired}
+	c.add("pinned.", rr)
+	c.pin("pinned.", []string{"pinned."})
+	st.Expect(t, len(c.get("pinned.")), 1)
+	for i := 0; i < 10; i++ {
+		k := fmt.Sprintf("%d.", i)
+		c.add(k, RR{Name: k, Type: "A", Value: "1.2.3.4"})
+	}
+	st.Expect(t, len(c.get("pinned.")), 1)
+	c.unpin("pinned.")
+	st.Expect(t, len(c.get("pinned.")), 0)
+	st.Expect(t, len(c.pinned), 0)
+	st.Expect(t, len(c.pins), 0)
+}
+
+func TestCacheAuthoritative(t *testing.T) {
+	c := newCache(10, false)
+	c.add("example.com.", RR{Name: "example.com.", Type: "A", Value: "203.0.113.1", Authoritative: true})
+	c.add("example.com.", RR{Name: "example.com.", Type: "A", Value: "203.0.113.1"})
+	rrs := c.get("example.com.")
+	st.Expect(t, len(rrs), 1)
+	st.Expect(t, rrs[0].Authoritative, true) // not demoted by glue
+}
+
+func TestCacheLRU(t *testing.T) {
+	c := newCache(3, true)
+	add := func(k string, expiry time.Time) {
+		c.add(k, RR{Name: k, Type: "A", Value: "1.2.3.4", Expiry: expiry})
+	}
+	live := time.Now().Add(time.Hour)
+	add("a.", live)
+	add("b.", live)
+	add("c.", live)
+	c.get("a.")
+	add("d.", live) // evicts b., the least recently used
+	st.Expect(t, c.get("b."), RRs(nil))
+	st.Expect(t, len(c.get("a.")), 1)
+	add("c.", live) // adding records to an entry uses it
+	add("e.", live) // evicts d.
+	st.Expect(t, c.get("d."), RRs(nil))
+	st.Expect(t, c.lru.Len(), 3)
+
+	add("a.", time.Now().Add(-time.Minute))
+	c.get("a.")
+	add("f.", live) // evicts a., which expired, rather than c.
+	st.Expect(t, c.entries["a."], (*entry)(nil))
+	st.Expect(t, len(c.get("c.")), 1)
+}
+
+func TestCacheEvictShortNames(t *testing.T) {
+	c := newCache(10, false)
+	c.add("com.", RR{Name: "com.", Type: "NS", Value: "a.gtld-servers.net."})
+	c.add("example.com.", RR{Name: "example.com.", Type: "NS", Value: "ns1.example.com."})
+	for i := 0; i < 20; i++ {
+		k := fmt.Sprintf("%d.example.com.", i)
+		c.add(k, RR{Name: k, Type: "A", Value: "1.2.3.4"})
+	}
+	st.Expect(t, len(c.entries), 10)
+	st.Expect(t, len(c.get("com.")), 1)
+	st.Expect(t, len(c.get("example.com.")), 1)
+
+	for i := 0; i < 20; i++ {
+		k := fmt.Sprintf("example%d.com.", i)
+		c.add(k, RR{Name: k, Type: "NS", Value: "ns1.example.com."})
+	}
+	st.Expect(t, len(c.entries), 10)
+	st.Expect(t, c.get("com."), RRs(nil))
+}
+
+func TestCacheSetCapacity(t *testing.T) {
+	c := newCache(10, false)
+	for i := 0; i < 10; i++ {
+		k := fmt.Sprintf("%d.", i)
+		c.add(k, RR{Name: k, Type: "A", Value: "1.2.3.4"})
+	}
+	c.pin("pinned.", []string{"0."})
+	st.Expect(t, len(c.entries), 10)
+	c.setCapacity(4)
+	st.Expect(t, len(c.entries), 4)
+	st.Expect(t, len(c.get("0.")), 1)
+	c.setCapacity(20)
+	for i := 10; i < 30; i++ {
+		k := fmt.Sprintf("%d.", i)
+		c.add(k, RR{Name: k, Type: "A", Value: "1.2.3.4"})
+	}
+	st.Expect(t, len(c.entries), 20)
+	c.setCapacity(0)
+	st.Expect(t, c.capacity, MinCacheCapacity)
+}
+
+func TestCacheEvictReason(t *testing.T) {
+	var mu sync.Mutex
+	got := make(map[string]EvictReason)
+	onEvict := func(qname string, reason EvictReason) {
+		mu.Lock()
+		got[qname] = reason
+		mu.Unlock()
+	}
+
+	c := newCache(2, true)
+	c.onEvict = onEvict
+	c.add("expired.", RR{Name: "expired.", Type: "A", Value: "1.2.3.4", Expiry: time.Now().Add(-time.Minute)})
+	c.add("fresh.", RR{Name: "fresh.", Type: "A", Value: "1.2.3.4", Expiry: time.Now().Add(time.Hour)})
+	c.add("new.", RR{Name: "new.", Type: "A", Value: "1.2.3.4"})
+	st.Expect(t, got, map[string]EvictReason{"expired.": EvictExpired})
+
+	c = newCache(2, false)
+	c.onEvict = onEvict
+	clear(got)
+	c.add("a.", RR{Name: "a.", Type: "A", Value: "1.2.3.4"})
+	c.add("b.", RR{Name: "b.", Type: "A", Value: "1.2.3.4"})
+	c.add("c.", RR{Name: "c.", Type: "A", Value: "1.2.3.4"})
+	st.Expect(t, len(got), 1)
+	for _, reason := range got {
+		st.Expect(t, reason, EvictCapacity)
+	}
+	c.setCapacity(1)
+	st.Expect(t, len(got), 2)
+	st.Expect(t, len(c.evicted), 0)
+
+	st.Expect(t, EvictExpired.String(), "expired")
+	st.Expect(t, EvictCapacity.String(), "capacity")
+}
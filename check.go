@@ -0,0 +1,274 @@
+package dnsr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// GlueMismatch describes a name server whose glue addresses in the parent zone
+// differ from the addresses served by the name servers of its own zone.
+type GlueMismatch struct {
+	Host          string   // name server host name
+	Glue          []string // A and AAAA addresses in the parent zone, sorted
+	Authoritative []string // A and AAAA addresses served authoritatively, sorted
+}
+
+// CheckGlue compares the glue addresses for the name servers of zone, as
+// returned by a name server for the parent zone, with the addresses served
+// authoritatively by the name servers of zone, and returns any mismatches.
+// The parent zone is the closest zone enclosing zone with name servers,
+// e.g. co.uk for a.b.example.co.uk if b.example.co.uk is not delegated.
+// It returns ErrNoDelegation if the parent zone doesn’t delegate zone.
+// Only name servers within zone, which require glue, are checked.
+// Name servers that can’t be checked are reported in the returned error,
+// along with any mismatches found for other name servers.
+func (r *Resolver) CheckGlue(ctx context.Context, zone string) ([]GlueMismatch, error) {
+	zone, err := r.normalize(zone)
+	if err != nil {
+		return nil, err
+	}
+	pname, ok := parent(zone)
+	if !ok {
+		return nil, ErrNoParent
+	}
+	var pservers []string
+	for ; ok; pname, ok = parent(pname) {
+		pservers, err = r.nameserverAddrs(ctx, pname)
+		if err != NXDOMAIN && err != ErrNoARecords {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the delegation from the parent zone
+	var rmsg *dns.Msg
+	err = ErrNoResponse
+	for _, ip := range pservers {
+		rmsg, err = r.queryServer(ctx, ip, zone, dns.TypeNS, dns.ClassINET)
+		if err == nil || err == NXDOMAIN {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	var hosts []string
+	delegated := false
+	for _, drr := range append(rmsg.Answer, rmsg.Ns...) {
+		if ns, ok := drr.(*dns.NS); ok && toLowerFQDN(ns.Hdr.Name) == zone {
+			delegated = true
+			host := toLowerFQDN(ns.Ns)
+			if dns.IsSubDomain(zone, host) {
+				hosts = append(hosts, host)
+			}
+		}
+	}
+	if !delegated {
+		return nil, ErrNoDelegation
+	}
+	sort.Strings(hosts)
+	glue := addrs(rmsg.Extra)
+	var servers []string
+	for _, host := range hosts {
+		servers = append(servers, glue[host]...)
+	}
+
+	// Compare with the addresses from the zone’s own name servers
+	var mismatches []GlueMismatch
+	var errs []error
+	for _, host := range hosts {
+		auth, err := r.authoritativeAddrs(ctx, servers, host)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", host, err))
+			continue
+		}
+		if !slices.Equal(glue[host], auth) {
+			mismatches = append(mismatches, GlueMismatch{Host: host, Glue: glue[host], Authoritative: auth})
+		}
+	}
+	return mismatches, errors.Join(errs...)
+}
+
+// CheckConsistency queries each name server of the closest zone enclosing qname
+// independently for records of type qtype, and returns the answer from each,
+// so the caller can detect name servers that disagree. Answers are returned
+// in order of name server IP address, with records sorted by name, type, and value.
+// Records do not expire, so answers can be compared with slices.Equal.
+// An NXDOMAIN response is an empty answer. Name servers that don’t respond
+// have a nil answer and are reported in the returned error.
+func (r *Resolver) CheckConsistency(ctx context.Context, qname, qtype string) ([]RRs, error) {
+	qname, err := r.normalize(qname)
+	if err != nil {
+		return nil, err
+	}
+	dtype := dns.StringToType[qtype]
+	if dtype == 0 {
+		dtype = dns.TypeA
+	}
+	var servers []string
+	for pname, ok := qname, true; ok; pname, ok = parent(pname) {
+		servers, err = r.nameserverAddrs(ctx, pname)
+		if err != NXDOMAIN && err != ErrNoARecords {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(servers)
+	servers = slices.Compact(servers)
+
+	answers := make([]RRs, len(servers))
+	errs := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, ip := range servers {
+		wg.Add(1)
+		go func(i int, ip string) {
+			defer wg.Done()
+			rmsg, err := r.queryServer(ctx, ip, qname, dtype, dns.ClassINET)
+			switch {
+			case err == NXDOMAIN:
+				answers[i] = RRs{}
+			case err != nil:
+				errs[i] = fmt.Errorf("%s: %w", ip, err)
+			default:
+				rrs := RRs{}
+				for _, drr := range rmsg.Answer {
+					if rr, ok := convertRR(drr, false); ok {
+						rrs = append(rrs, rr)
+					}
+				}
+				slices.SortFunc(rrs, compareRRs)
+				answers[i] = rrs
+			}
+		}(i, ip)
+	}
+	wg.Wait()
+	return answers, errors.Join(errs...)
+}
+
+// CheckNameservers queries each of servers, the IP addresses of candidate
+// name servers, directly for the SOA record of zone, and reports for each
+// whether it serves zone: nil if it answered authoritatively with the SOA,
+// ErrNotAuthoritative if it answered otherwise, or the error from the query.
+// Servers are queried in parallel. It returns an error only if zone is invalid.
+func (r *Resolver) CheckNameservers(ctx context.Context, zone string, servers []string) (map[string]error, error) {
+	zone, err := r.normalize(zone)
+	if err != nil {
+		return nil, err
+	}
+	results := make(map[string]error, len(servers))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server string) {
+			defer wg.Done()
+			err := r.checkNameserver(ctx, zone, server)
+			mu.Lock()
+			results[server] = err
+			mu.Unlock()
+		}(server)
+	}
+	wg.Wait()
+	return results, nil
+}
+
+// checkNameserver checks that the name server at IP address server
+// answers authoritatively with the SOA record for a normalized zone.
+func (r *Resolver) checkNameserver(ctx context.Context, zone, server string) error {
+	if net.ParseIP(server) == nil {
+		return ErrInvalidServer
+	}
+	rmsg, err := r.queryServer(ctx, server, zone, dns.TypeSOA, dns.ClassINET)
+	if err != nil {
+		return err
+	}
+	if !rmsg.Authoritative || !slices.ContainsFunc(rmsg.Answer, func(drr dns.RR) bool {
+		return drr.Header().Rrtype == dns.TypeSOA && toLowerFQDN(drr.Header().Name) == zone
+	}) {
+		return ErrNotAuthoritative
+	}
+	return nil
+}
+
+// nameserverAddrs resolves the addresses of the name servers for zone,
+// of the address families the Resolver queries.
+func (r *Resolver) nameserverAddrs(ctx context.Context, zone string) ([]string, error) {
+	rrs, err := r.ResolveContext(ctx, zone, "NS")
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	var ips []string
+	for _, rr := range rrs {
+		if rr.Type != "NS" || rr.Name != zone {
+			continue
+		}
+		hips, err := r.nameserverIPs(ctx, rr.Value, 0)
+		if err != nil {
+			continue
+		}
+		ips = append(ips, hips...)
+	}
+	if len(ips) == 0 {
+		return nil, ErrNoARecords
+	}
+	return ips, nil
+}
+
+// authoritativeAddrs returns the sorted A and AAAA addresses for host from
+// the first of servers to respond authoritatively for each type.
+func (r *Resolver) authoritativeAddrs(ctx context.Context, servers []string, host string) ([]string, error) {
+	var ips []string
+	for _, dtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		found := false
+		for _, ip := range servers {
+			rmsg, err := r.queryServer(ctx, ip, host, dtype, dns.ClassINET)
+			if err == NXDOMAIN {
+				found = true
+				break
+			}
+			if err != nil || !rmsg.Authoritative {
+				continue
+			}
+			ips = append(ips, addrs(rmsg.Answer)[host]...)
+			found = true
+			break
+		}
+		if !found {
+			return nil, ErrNoResponse
+		}
+	}
+	sort.Strings(ips)
+	return ips, nil
+}
+
+// addrs returns the sorted A and AAAA addresses in drrs by owner name.
+func addrs(drrs []dns.RR) map[string][]string {
+	m := make(map[string][]string)
+	for _, drr := range drrs {
+		switch t := drr.(type) {
+		case *dns.A:
+			name := toLowerFQDN(t.Hdr.Name)
+			m[name] = append(m[name], t.A.String())
+		case *dns.AAAA:
+			name := toLowerFQDN(t.Hdr.Name)
+			m[name] = append(m[name], t.AAAA.String())
+		}
+	}
+	for _, ips := range m {
+		sort.Strings(ips)
+	}
+	return m
+}
@@ -0,0 +1,175 @@
+package dnsr
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/domainr/dnsr/dnsrtest"
+	"github.com/miekg/dns"
+	"github.com/nbio/st"
+)
+
+// newTestGlueResolver returns a Resolver for testZoneRecords where
+// the parent zone returns glue for ns1.example.com.
+func newTestGlueResolver(t *testing.T, glue string) *Resolver {
+	zone := newTestZone(t, append([]string{"ns2.example.com. 172800 IN AAAA 2001:db8::54"}, testZoneRecords...)...)
+	s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := zone.reply(req)
+		if q := req.Question[0]; q.Name == "example.com." && q.Qtype == dns.TypeNS {
+			m.Authoritative = false
+			for i, rr := range m.Extra {
+				if a, ok := rr.(*dns.A); ok && a.Hdr.Name == "ns1.example.com." {
+					a = dns.Copy(a).(*dns.A)
+					a.A = net.ParseIP(glue)
+					m.Extra[i] = a
+				}
+			}
+		}
+		w.WriteMsg(m)
+	}))
+	return NewResolver(WithDialer(s.Dialer()))
+}
+
+func TestCheckGlue(t *testing.T) {
+	ctx := context.Background()
+	r := newTestGlueResolver(t, "192.0.2.53")
+	mismatches, err := r.CheckGlue(ctx, "example.com")
+	st.Expect(t, err, nil)
+	st.Expect(t, len(mismatches), 0)
+
+	r = newTestGlueResolver(t, "192.0.2.99")
+	mismatches, err = r.CheckGlue(ctx, "Example.com")
+	st.Expect(t, err, nil)
+	st.Expect(t, mismatches, []GlueMismatch{{
+		Host:          "ns1.example.com.",
+		Glue:          []string{"192.0.2.99"},
+		Authoritative: []string{"192.0.2.53"},
+	}})
+
+	_, err = r.CheckGlue(ctx, "nx.example.com")
+	st.Expect(t, err, NXDOMAIN)
+	_, err = r.CheckGlue(ctx, "www.example.com")
+	st.Expect(t, err, ErrNoDelegation)
+	_, err = r.CheckGlue(ctx, ".")
+	st.Expect(t, err, ErrNoParent)
+	_, err = r.CheckGlue(ctx, "invalid..example.com")
+	st.Expect(t, err, ErrInvalidName)
+}
+
+func TestCheckGlueGrandparent(t *testing.T) {
+	// a.b.example.com is delegated by example.com, not b.example.com
+	records := append([]string{
+		"a.b.example.com. 172800 IN NS ns1.a.b.example.com.",
+		"ns1.a.b.example.com. 172800 IN A 192.0.2.55",
+		"a.b.example.com. 3600 IN SOA ns1.a.b.example.com. hostmaster.a.b.example.com. 1 7200 3600 1209600 300",
+	}, testZoneRecords...)
+	r, _ := newTestResolver(t, records)
+	mismatches, err := r.CheckGlue(context.Background(), "a.b.example.com")
+	st.Expect(t, err, nil)
+	st.Expect(t, len(mismatches), 0)
+}
+
+func TestCheckConsistency(t *testing.T) {
+	reply := func(records ...string) *dns.Msg {
+		m := new(dns.Msg)
+		m.Authoritative = true
+		for _, s := range records {
+			rr, err := dns.NewRR(s)
+			st.Assert(t, err, nil)
+			m.Answer = append(m.Answer, rr)
+		}
+		return m
+	}
+	var tr dnsrtest.MemoryTransport
+	tr.Add("", "com", "NS", reply("com. 172800 IN NS a.gtld-servers.net."))
+	tr.Add("", "a.gtld-servers.net", "A", reply("a.gtld-servers.net. 172800 IN A 192.0.2.1"))
+	tr.Add("", "example.com", "NS", reply("example.com. 172800 IN NS ns1.example.com.", "example.com. 172800 IN NS ns2.example.com."))
+	tr.Add("", "ns1.example.com", "A", reply("ns1.example.com. 172800 IN A 192.0.2.53"))
+	tr.Add("", "ns2.example.com", "A", reply("ns2.example.com. 172800 IN A 192.0.2.54"))
+	tr.Add("", "www.example.com", "NS", reply())
+	tr.Add("192.0.2.53", "www.example.com", "A", reply("www.example.com. 300 IN A 203.0.113.1"))
+	tr.Add("192.0.2.54", "www.example.com", "A", reply("www.example.com. 300 IN A 203.0.113.2"))
+	nx := new(dns.Msg)
+	nx.Rcode = dns.RcodeNameError
+	tr.Add("", "nx.example.com", "NS", nx)
+	tr.Add("192.0.2.53", "nx.example.com", "A", nx)
+	tr.Add("192.0.2.54", "nx.example.com", "A", nx)
+	r := NewResolver(WithTransport(&tr), WithRootServers([]string{"192.0.2.250"}), WithDialer(failDialer{}))
+	ctx := context.Background()
+
+	answers, err := r.CheckConsistency(ctx, "www.example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, answers, []RRs{
+		{{Name: "www.example.com.", Type: "A", Value: "203.0.113.1", TTL: 300 * time.Second}},
+		{{Name: "www.example.com.", Type: "A", Value: "203.0.113.2", TTL: 300 * time.Second}},
+	})
+
+	answers, err = r.CheckConsistency(ctx, "nx.example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, answers, []RRs{{}, {}})
+
+	answers, err = r.CheckConsistency(ctx, "www.example.com", "MX")
+	st.Expect(t, err != nil, true)
+	st.Expect(t, answers, []RRs{nil, nil})
+}
+
+func TestCheckConsistencyIPv6(t *testing.T) {
+	reply := func(records ...string) *dns.Msg {
+		m := new(dns.Msg)
+		m.Authoritative = true
+		for _, s := range records {
+			rr, err := dns.NewRR(s)
+			st.Assert(t, err, nil)
+			m.Answer = append(m.Answer, rr)
+		}
+		return m
+	}
+	var tr dnsrtest.MemoryTransport
+	// Referrals with IPv4 and IPv6 glue
+	referral := func(ns string, glue ...string) *dns.Msg {
+		m := reply(ns)
+		m.Extra = reply(glue...).Answer
+		return m
+	}
+	tr.Add("", "com", "NS", referral("com. 172800 IN NS a.gtld-servers.net.", "a.gtld-servers.net. 172800 IN A 192.0.2.1", "a.gtld-servers.net. 172800 IN AAAA 2001:db8::1"))
+	tr.Add("", "example.com", "NS", referral("example.com. 172800 IN NS ns1.example.com.", "ns1.example.com. 172800 IN A 192.0.2.53", "ns1.example.com. 172800 IN AAAA 2001:db8::53"))
+	tr.Add("", "www.example.com", "NS", reply())
+	tr.Add("2001:db8::53", "www.example.com", "A", reply("www.example.com. 300 IN A 203.0.113.1"))
+	r := NewResolver(WithTransport(&tr), WithRootServers([]string{"2001:db8::250"}), WithDialer(failDialer{}), WithAddressFamily(IPv6Only))
+
+	// Name servers are queried at addresses of the Resolver’s address family
+	answers, err := r.CheckConsistency(context.Background(), "www.example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, answers, []RRs{
+		{{Name: "www.example.com.", Type: "A", Value: "203.0.113.1", TTL: 300 * time.Second}},
+	})
+	st.Expect(t, slices.Contains(tr.Queries(), "192.0.2.53 www.example.com. A"), false)
+}
+
+func TestCheckNameservers(t *testing.T) {
+	soa, err := dns.NewRR("example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300")
+	st.Assert(t, err, nil)
+	auth := &dns.Msg{MsgHdr: dns.MsgHdr{Authoritative: true}, Answer: []dns.RR{soa}}
+	recursive := &dns.Msg{MsgHdr: dns.MsgHdr{RecursionAvailable: true}, Answer: []dns.RR{soa}}
+	refused := &dns.Msg{MsgHdr: dns.MsgHdr{Rcode: dns.RcodeRefused}}
+	var tr dnsrtest.MemoryTransport
+	tr.Add("192.0.2.53", "example.com", "SOA", auth)
+	tr.Add("192.0.2.54", "example.com", "SOA", recursive)
+	tr.Add("192.0.2.55", "example.com", "SOA", refused)
+	r := NewResolver(WithTransport(&tr))
+
+	results, err := r.CheckNameservers(context.Background(), "Example.com", []string{"192.0.2.53", "192.0.2.54", "192.0.2.55", "192.0.2.56", "ns1.example.com"})
+	st.Expect(t, err, nil)
+	st.Expect(t, len(results), 5)
+	st.Expect(t, results["192.0.2.53"], nil)
+	st.Expect(t, results["192.0.2.54"], ErrNotAuthoritative)
+	st.Expect(t, results["192.0.2.55"], rcodeError(dns.RcodeRefused))
+	st.Expect(t, results["192.0.2.56"], dnsrtest.ErrNoResponse)
+	st.Expect(t, results["ns1.example.com"], ErrInvalidServer)
+
+	_, err = r.CheckNameservers(context.Background(), "", []string{"192.0.2.53"})
+	st.Expect(t, err, ErrInvalidName)
+}
@@ -0,0 +1,104 @@
+package dnsr
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// DelegationLevel describes the name servers for a zone in a delegation chain.
+type DelegationLevel struct {
+	Zone string // zone name, e.g. "com."
+	NS   RRs    // NS records for the zone
+	Glue RRs    // known A and AAAA records for the name servers
+}
+
+// Delegation resolves the chain of delegations for qname, from the root zone
+// to the closest zone enclosing qname, including qname if it is a zone.
+// If resolution fails partway, Delegation returns the levels resolved so far
+// along with the error. All levels are resolved within the Resolver timeout.
+func (r *Resolver) Delegation(ctx context.Context, qname string) ([]DelegationLevel, error) {
+	qname, err := r.normalize(qname)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	var zones []string
+	for pname, ok := qname, true; ok; pname, ok = parent(pname) {
+		zones = append(zones, pname)
+	}
+	slices.Reverse(zones)
+
+	var levels []DelegationLevel
+	for _, zone := range zones {
+		rrs, err := r.resolveTop(ctx, zone, "NS")
+		if err != nil && err != ErrNoData {
+			return levels, err
+		}
+		level := DelegationLevel{Zone: zone}
+		for _, rr := range rrs {
+			if rr.Type != "NS" || rr.Name != zone {
+				continue
+			}
+			level.NS = append(level.NS, rr)
+			arrs, _ := r.cacheLookup(rr.Value, "")
+			for _, arr := range arrs {
+				if arr.Type == "A" || arr.Type == "AAAA" {
+					level.Glue = append(level.Glue, arr)
+				}
+			}
+		}
+		if level.NS != nil {
+			levels = append(levels, level)
+		}
+	}
+	return levels, nil
+}
+
+// RegistrableDomain returns an approximation of the registrable domain
+// (eTLD+1) for name, e.g. "example.co.uk." for "www.example.co.uk".
+// It is based on delegation, not the Public Suffix List: the public suffix
+// is taken to be the top-level domain and any zones below it that share
+// name servers with it, as registries typically serve their second-level
+// zones such as co.uk from the same name servers. The registrable domain
+// is the public suffix with one more label of name.
+// It returns ErrPublicSuffix if name is a public suffix.
+func (r *Resolver) RegistrableDomain(ctx context.Context, name string) (string, error) {
+	levels, err := r.Delegation(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	name, _ = r.normalize(name)
+	var suffix *DelegationLevel
+	for i := range levels {
+		level := &levels[i]
+		if level.Zone == "." {
+			continue
+		}
+		if suffix != nil && !sharesNameservers(suffix.NS, level.NS) {
+			break
+		}
+		suffix = level
+	}
+	if suffix == nil || name == suffix.Zone {
+		return "", ErrPublicSuffix
+	}
+	labels := dns.SplitDomainName(name)
+	n := dns.CountLabel(suffix.Zone) + 1
+	return toLowerFQDN(strings.Join(labels[len(labels)-n:], ".")), nil
+}
+
+// sharesNameservers reports whether NS records a and b have a name server in common.
+func sharesNameservers(a, b RRs) bool {
+	for _, x := range a {
+		for _, y := range b {
+			if x.Value == y.Value {
+				return true
+			}
+		}
+	}
+	return false
+}
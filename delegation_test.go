@@ -0,0 +1,78 @@
+package dnsr
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/nbio/st"
+)
+
+func TestDelegation(t *testing.T) {
+	r, _ := newTestResolver(t, testZoneRecords)
+	levels, err := r.Delegation(context.Background(), "www.example.com")
+	st.Expect(t, err, nil)
+	st.Assert(t, len(levels), 3)
+	st.Expect(t, levels[0].Zone, ".")
+	st.Expect(t, len(levels[0].NS) > 0, true)
+	st.Expect(t, len(levels[0].Glue) > 0, true)
+	st.Expect(t, levels[1].Zone, "com.")
+	st.Expect(t, count(levels[1].NS, func(rr RR) bool { return rr.Value == "a.gtld-servers.net." }), 1)
+	st.Expect(t, levels[2].Zone, "example.com.")
+	st.Expect(t, len(levels[2].NS), 2)
+	st.Expect(t, count(levels[2].Glue, func(rr RR) bool { return rr.Value == "192.0.2.53" || rr.Value == "192.0.2.54" }), 2)
+
+	levels, err = r.Delegation(context.Background(), "nx.example.com")
+	st.Expect(t, err, nil)
+	st.Expect(t, len(levels), 3) // the closest enclosing zone is example.com
+
+	_, err = NewResolver(WithAllowlist([]string{"example.net"})).Delegation(context.Background(), "example.com")
+	st.Expect(t, err, ErrNotAllowed)
+}
+
+func TestDelegationTimeout(t *testing.T) {
+	zone := newTestZone(t, testZoneRecords...)
+	s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		time.Sleep(250 * time.Millisecond)
+		zone.ServeDNS(w, req)
+	}))
+	// Each level resolves within the timeout, but not all of them
+	r := NewResolver(WithDialer(s.Dialer()), WithTimeout(400*time.Millisecond))
+	levels, err := r.Delegation(context.Background(), "example.com")
+	st.Expect(t, err, ErrTimeout)
+	st.Expect(t, len(levels) < 3, true)
+}
+
+func TestRegistrableDomain(t *testing.T) {
+	records := append([]string{
+		"uk. 172800 IN NS nsa.nic.uk.",
+		"nsa.nic.uk. 172800 IN A 192.0.2.10",
+		"uk. 900 IN SOA nsa.nic.uk. hostmaster.nominet.org.uk. 1 900 300 2419200 10800",
+		"co.uk. 172800 IN NS nsa.nic.uk.",
+		"co.uk. 900 IN SOA nsa.nic.uk. hostmaster.nominet.org.uk. 1 900 300 2419200 10800",
+		"example.co.uk. 172800 IN NS ns1.example.co.uk.",
+		"ns1.example.co.uk. 172800 IN A 192.0.2.55",
+		"example.co.uk. 3600 IN SOA ns1.example.co.uk. hostmaster.example.co.uk. 1 7200 3600 1209600 300",
+		"example.co.uk. 300 IN A 203.0.113.2",
+		"www.example.co.uk. 300 IN A 203.0.113.2",
+		"a.b.example.com. 300 IN A 203.0.113.3",
+	}, testZoneRecords...)
+	r, _ := newTestResolver(t, records)
+	ctx := context.Background()
+	for name, want := range map[string]string{
+		"www.example.co.uk": "example.co.uk.",
+		"example.co.uk":     "example.co.uk.",
+		"www.example.com":   "example.com.",
+		"A.B.Example.com":   "example.com.",
+		"example.com.":      "example.com.",
+	} {
+		got, err := r.RegistrableDomain(ctx, name)
+		st.Expect(t, err, nil)
+		st.Expect(t, got, want)
+	}
+	for _, name := range []string{"co.uk", "uk", "com", "."} {
+		_, err := r.RegistrableDomain(ctx, name)
+		st.Expect(t, err, ErrPublicSuffix)
+	}
+}
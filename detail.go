@@ -0,0 +1,340 @@
+package dnsr
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Detail describes how a resolution was performed.
+type Detail struct {
+	// Depth is the maximum recursion depth reached.
+	// A resolution answered from the cache has depth 1.
+	// Depth exceeds MaxRecursion if the resolution failed with ErrMaxRecursion.
+	Depth int
+
+	// Iterations is the number of parent zones iterated to find name servers,
+	// across all recursive resolutions.
+	Iterations int
+
+	// Nameservers is the number of distinct name server addresses queried,
+	// across all recursive resolutions.
+	Nameservers int
+
+	// QueriedServers lists the distinct name server addresses queried, sorted.
+	QueriedServers []string
+
+	// CNAMEChain lists the targets of the CNAME records followed from qname,
+	// in order, ending with the name holding the answer. It is nil if the
+	// answer did not require following a CNAME.
+	CNAMEChain []string
+
+	// AnsweredBy is the address of the name server whose response answered
+	// qname, or the CNAME for it. It is empty if the answer was cached.
+	AnsweredBy string
+
+	// Additional lists the records in the additional section of the response
+	// from AnsweredBy, including any not cached, such as out-of-bailiwick hints.
+	Additional RRs
+
+	// Reason describes why the result has no answer records, if it doesn’t.
+	Reason Reason
+
+	// Authenticated is true if the answer was validated with DNSSEC,
+	// like the AD bit of a response. See WithDNSSEC.
+	Authenticated bool
+
+	// FromCache is true if the result was answered from the cache.
+	// With WithDNSSEC, name servers may still be queried to validate it.
+	FromCache bool
+
+	// Rcode is the DNS response code a recursive resolver would return
+	// for the result: dns.RcodeSuccess for answers, including NODATA,
+	// dns.RcodeNameError for NXDOMAIN, the response code of a failed
+	// response, or dns.RcodeServerFailure for other failures.
+	Rcode int
+
+	// Elapsed is the duration of the resolution.
+	Elapsed time.Duration
+}
+
+// Reason describes why a resolution returned no answer records without an error.
+type Reason int
+
+const (
+	ReasonNone            Reason = iota // the result has answer records, or resolution failed
+	ReasonNoData                        // the name exists, but has no records of the queried type
+	ReasonDanglingCNAME                 // the name is a CNAME whose target has no records of the queried type
+	ReasonNoAuthoritative               // no name server answered authoritatively, e.g. a lame delegation
+)
+
+func (reason Reason) String() string {
+	switch reason {
+	case ReasonNone:
+		return "none"
+	case ReasonNoData:
+		return "no data"
+	case ReasonDanglingCNAME:
+		return "dangling CNAME"
+	case ReasonNoAuthoritative:
+		return "no authoritative answer"
+	}
+	return "unknown"
+}
+
+// ResolveContextDetail is like ResolveContext, and also returns
+// details of the resolution, even if it fails.
+func (r *Resolver) ResolveContextDetail(ctx context.Context, qname, qtype string) (RRs, Detail, error) {
+	start := time.Now()
+	query := qname
+	qname, err := r.normalize(qname)
+	if err != nil {
+		return nil, Detail{}, err
+	}
+	rrs, detail, err := r.resolveDetail(ctx, qname, qtype, &trace{servers: make(map[string]struct{})})
+	if qtype != "CNAME" {
+		detail.CNAMEChain = r.cnameChain(qname, rrs)
+	}
+	switch {
+	case err == nil:
+		detail.Reason = r.reason(qname, qtype, rrs, detail.CNAMEChain)
+	case err == ErrNoData && len(detail.CNAMEChain) > 0:
+		detail.Reason = ReasonDanglingCNAME
+	case err == ErrNoData:
+		detail.Reason = ReasonNoData
+	}
+	detail.Rcode = rcodeOf(err)
+	detail.Elapsed = time.Since(start)
+	return r.returned(query, qname, rrs), *detail, err
+}
+
+// rcodeOf returns the DNS response code for a resolution that failed with err.
+func rcodeOf(err error) int {
+	switch err {
+	case nil, ErrNoData:
+		return dns.RcodeSuccess
+	case NXDOMAIN:
+		return dns.RcodeNameError
+	}
+	var rerr *resolverError
+	if errors.As(err, &rerr) {
+		if rcode, ok := dns.StringToRcode[rerr.s]; ok {
+			return rcode
+		}
+	}
+	return dns.RcodeServerFailure
+}
+
+// reason returns the Reason that rrs, resolved for qname and qtype
+// by following chain, have no answer records, if they don’t.
+func (r *Resolver) reason(qname, qtype string, rrs RRs, chain []string) Reason {
+	names := map[string]bool{qname: true}
+	for _, name := range chain {
+		names[name] = true
+	}
+	for _, rr := range rrs {
+		if names[rr.Name] && (rr.Type == qtype || (qtype == "" && rr.Type != "CNAME")) {
+			return ReasonNone
+		}
+	}
+	switch {
+	case len(chain) > 0:
+		return ReasonDanglingCNAME
+	case r.cache.hasNoData(qname, qtype) || slices.ContainsFunc(rrs, func(rr RR) bool { return rr.Authoritative }):
+		return ReasonNoData
+	default:
+		return ReasonNoAuthoritative
+	}
+}
+
+// cnameChain returns the targets of the CNAME records followed from qname,
+// found in rrs or, for results answered from the cache, in the cache.
+func (r *Resolver) cnameChain(qname string, rrs RRs) []string {
+	var chain []string
+	seen := map[string]bool{qname: true}
+	for name := qname; ; {
+		target, ok := cnameTarget(rrs, name)
+		if !ok {
+			target, ok = cnameTarget(r.cache.get(name), name)
+		}
+		if !ok || seen[target] {
+			return chain
+		}
+		name = target
+		seen[name] = true
+		chain = append(chain, name)
+	}
+}
+
+// cnameTarget returns the target of the CNAME record for name in rrs, if any.
+func cnameTarget(rrs RRs, name string) (string, bool) {
+	for _, rr := range rrs {
+		if rr.Type == "CNAME" && rr.Name == name {
+			return rr.Value, true
+		}
+	}
+	return "", false
+}
+
+// trace accumulates a Detail during a resolution.
+// Name servers and additional sections are recorded only if servers is non-nil.
+// It is safe for concurrent use by the goroutines of a resolution.
+type trace struct {
+	mu         sync.Mutex
+	detail     Detail
+	servers    map[string]struct{}
+	additional map[string]RRs // additional sections of responses by name server address
+}
+
+type traceKey struct{}
+
+// traceFrom returns the trace in ctx, or nil.
+func traceFrom(ctx context.Context) *trace {
+	t, _ := ctx.Value(traceKey{}).(*trace)
+	return t
+}
+
+// depth records that a resolution reached depth.
+func (t *trace) depth(depth int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if depth > t.detail.Depth {
+		t.detail.Depth = depth
+	}
+	t.mu.Unlock()
+}
+
+// iteration records a parent zone iteration.
+func (t *trace) iteration() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.detail.Iterations++
+	t.mu.Unlock()
+}
+
+// server records a query sent to the name server at ip.
+func (t *trace) server(ip string) {
+	if t == nil || t.servers == nil {
+		return
+	}
+	t.mu.Lock()
+	t.servers[ip] = struct{}{}
+	t.mu.Unlock()
+}
+
+// answeredBy records that the name server at ip answered the resolution.
+func (t *trace) answeredBy(ip string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.detail.AnsweredBy = ip
+	t.detail.Additional = t.additional[ip]
+	t.mu.Unlock()
+}
+
+// fromCache records that the resolution was answered from the cache.
+func (t *trace) fromCache() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.detail.FromCache = true
+	t.mu.Unlock()
+}
+
+// authenticated records whether the answer was validated with DNSSEC.
+func (t *trace) authenticated(secure bool) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	t.detail.Authenticated = secure
+	t.mu.Unlock()
+}
+
+// extra records the additional section drrs of a response to qname
+// from the name server at ip.
+func (t *trace) extra(ip string, drrs []dns.RR) {
+	if t == nil || t.servers == nil {
+		return
+	}
+	var rrs RRs
+	for _, drr := range drrs {
+		if _, ok := drr.(*dns.OPT); ok {
+			continue
+		}
+		if rr, ok := convertRR(drr, false); ok {
+			rrs = append(rrs, rr)
+		}
+	}
+	t.mu.Lock()
+	if t.additional == nil {
+		t.additional = make(map[string]RRs)
+	}
+	t.additional[ip] = rrs
+	t.mu.Unlock()
+}
+
+// merge records the resolution traced by u as part of the resolution traced by t.
+func (t *trace) merge(u *trace) {
+	if t == nil || u == nil {
+		return
+	}
+	u.mu.Lock()
+	d := u.detail
+	var servers []string
+	for ip := range u.servers {
+		servers = append(servers, ip)
+	}
+	additional := make(map[string]RRs, len(u.additional))
+	for ip, rrs := range u.additional {
+		additional[ip] = rrs
+	}
+	u.mu.Unlock()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.detail.Depth = max(t.detail.Depth, d.Depth)
+	t.detail.Iterations += d.Iterations
+	if d.AnsweredBy != "" {
+		t.detail.AnsweredBy = d.AnsweredBy
+		t.detail.Additional = d.Additional
+	}
+	if t.servers == nil {
+		return
+	}
+	for _, ip := range servers {
+		t.servers[ip] = struct{}{}
+	}
+	if t.additional == nil {
+		t.additional = additional
+		return
+	}
+	for ip, rrs := range additional {
+		t.additional[ip] = rrs
+	}
+}
+
+// result returns a copy of the accumulated Detail.
+func (t *trace) result() *Detail {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	d := t.detail
+	if t.servers != nil {
+		d.Nameservers = len(t.servers)
+		for ip := range t.servers {
+			d.QueriedServers = append(d.QueriedServers, ip)
+		}
+		slices.Sort(d.QueriedServers)
+	}
+	return &d
+}
@@ -0,0 +1,185 @@
+package dnsr
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/domainr/dnsr/dnsrtest"
+	"github.com/miekg/dns"
+	"github.com/nbio/st"
+)
+
+func TestResolveContextDetail(t *testing.T) {
+	r, _ := newTestResolver(t, testZoneRecords)
+	ctx := context.Background()
+	rrs, detail, err := r.ResolveContextDetail(ctx, "www.example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" }) >= 1, true)
+	st.Expect(t, detail.Depth >= 3, true) // www.example.com A → example.com NS → com NS
+	st.Expect(t, detail.Iterations >= 3, true)
+	st.Expect(t, detail.Nameservers, len(detail.QueriedServers))
+	st.Expect(t, detail.Nameservers >= 3, true) // root, com, example.com
+	st.Expect(t, slices.IsSorted(detail.QueriedServers), true)
+	st.Expect(t, slices.Contains(detail.QueriedServers, "192.0.2.1"), true) // a.gtld-servers.net
+	st.Expect(t, detail.CNAMEChain, []string{"example.com."})
+
+	_, detail, err = r.ResolveContextDetail(ctx, "www.example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, detail.Elapsed > 0, true)
+	detail.Elapsed = 0
+	st.Expect(t, detail, Detail{Depth: 1, CNAMEChain: []string{"example.com."}, FromCache: true})
+
+	_, detail, err = r.ResolveContextDetail(ctx, "example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, detail.CNAMEChain, []string(nil))
+
+	_, detail, err = r.ResolveContextDetail(ctx, "nx.example.com", "A")
+	st.Expect(t, err, NXDOMAIN)
+	st.Expect(t, detail.Depth >= 1, true)
+
+	_, detail, err = NewResolver(WithAllowlist([]string{"example.net"})).ResolveContextDetail(ctx, "example.com", "A")
+	st.Expect(t, err, ErrNotAllowed)
+	st.Expect(t, detail, Detail{})
+}
+
+func TestResolveContextDetailCNAMEChain(t *testing.T) {
+	records := append([]string{
+		"a.example.com. 300 IN CNAME b.example.com.",
+		"b.example.com. 300 IN CNAME c.example.com.",
+		"c.example.com. 300 IN CNAME example.com.",
+	}, testZoneRecords...)
+	r, _ := newTestResolver(t, records)
+	ctx := context.Background()
+	rrs, detail, err := r.ResolveContextDetail(ctx, "A.example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" }) >= 1, true)
+	st.Expect(t, detail.CNAMEChain, []string{"b.example.com.", "c.example.com.", "example.com."})
+
+	st.Expect(t, r.cnameChain("loop1.example.com.", RRs{
+		{Name: "loop1.example.com.", Type: "CNAME", Value: "loop2.example.com."},
+		{Name: "loop2.example.com.", Type: "CNAME", Value: "loop1.example.com."},
+	}), []string{"loop2.example.com."})
+}
+
+func TestResolveContextDetailReason(t *testing.T) {
+	records := append([]string{
+		"dangling.example.com. 300 IN CNAME nowhere.example.com.",
+		"lame.example.com. 300 IN NS ns1.example.com.",
+	}, testZoneRecords...)
+	r, _ := newTestResolver(t, records)
+	ctx := context.Background()
+	tests := []struct {
+		qname, qtype string
+		reason       Reason
+	}{
+		{"example.com", "A", ReasonNone},
+		{"example.com", "MX", ReasonNoData},
+		{"dangling.example.com", "A", ReasonDanglingCNAME},
+		{"lame.example.com", "A", ReasonNoAuthoritative},
+	}
+	for _, tt := range tests {
+		_, detail, err := r.ResolveContextDetail(ctx, tt.qname, tt.qtype)
+		st.Expect(t, err, nil)
+		st.Expect(t, detail.Reason, tt.reason)
+	}
+	st.Expect(t, ReasonDanglingCNAME.String(), "dangling CNAME")
+}
+
+func TestResolveContextDetailAnsweredBy(t *testing.T) {
+	reply := func(records ...string) *dns.Msg {
+		m := new(dns.Msg)
+		m.Authoritative = true
+		for _, s := range records {
+			rr, err := dns.NewRR(s)
+			st.Assert(t, err, nil)
+			m.Answer = append(m.Answer, rr)
+		}
+		return m
+	}
+	var tr dnsrtest.MemoryTransport
+	tr.Add("", "com", "NS", reply("com. 172800 IN NS a.gtld-servers.net."))
+	tr.Add("", "a.gtld-servers.net", "A", reply("a.gtld-servers.net. 172800 IN A 192.0.2.1"))
+	tr.Add("", "example.com", "NS", reply("example.com. 172800 IN NS ns1.example.com.", "example.com. 172800 IN NS ns2.example.com."))
+	tr.Add("", "ns1.example.com", "A", reply("ns1.example.com. 172800 IN A 192.0.2.53"))
+	tr.Add("", "ns2.example.com", "A", reply("ns2.example.com. 172800 IN A 192.0.2.54"))
+	tr.Add("192.0.2.53", "www.example.com", "A", reply("www.example.com. 300 IN A 203.0.113.1"))
+	tr.Add("192.0.2.54", "www.example.com", "TXT", reply(`www.example.com. 300 IN TXT "hello"`))
+	r := NewResolver(WithTransport(&tr), WithRootServers([]string{"192.0.2.250"}), WithDialer(failDialer{}))
+	ctx := context.Background()
+
+	answeredBy := make(map[string]string)
+	for _, qtype := range []string{"A", "TXT"} {
+		_, detail, err := r.ResolveContextDetail(ctx, "www.example.com", qtype)
+		st.Expect(t, err, nil)
+		answeredBy[qtype] = detail.AnsweredBy
+	}
+	st.Expect(t, answeredBy, map[string]string{"A": "192.0.2.53", "TXT": "192.0.2.54"})
+
+	_, detail, err := r.ResolveContextDetail(ctx, "www.example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, detail.AnsweredBy, "") // cached
+}
+
+func TestResolveContextDetailAdditional(t *testing.T) {
+	zone := newTestZone(t, append([]string{`example.com. 300 IN TXT "hello"`}, testZoneRecords...)...)
+	hint, err := dns.NewRR("cdn.example.net. 300 IN A 198.51.100.1")
+	st.Assert(t, err, nil)
+	s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := zone.reply(req)
+		if q := req.Question[0]; q.Name == "example.com." && q.Qtype == dns.TypeTXT {
+			m.Extra = append(m.Extra, hint)
+			m.SetEdns0(dns.DefaultMsgSize, false)
+		}
+		w.WriteMsg(m)
+	}))
+	r := NewResolver(WithDialer(s.Dialer()))
+	rrs, detail, err := r.ResolveContextDetail(context.Background(), "example.com", "TXT")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Name == "cdn.example.net." }), 0)
+	st.Expect(t, detail.Additional, RRs{{Name: "cdn.example.net.", Type: "A", Value: "198.51.100.1", TTL: 300 * time.Second}})
+
+	_, detail, err = r.ResolveContextDetail(context.Background(), "example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, len(detail.Additional), 0)
+}
+
+func TestResolveContextDetailFromCache(t *testing.T) {
+	r, _ := newTestResolver(t, testZoneRecords)
+	ctx := context.Background()
+	tests := []struct {
+		qname     string
+		err       error
+		fromCache bool
+		rcode     int
+	}{
+		{"example.com", nil, false, dns.RcodeSuccess},
+		{"example.com", nil, true, dns.RcodeSuccess},
+		{"nx.example.com", NXDOMAIN, false, dns.RcodeNameError},
+		{"nx.example.com", NXDOMAIN, true, dns.RcodeNameError},
+	}
+	for _, tt := range tests {
+		_, detail, err := r.ResolveContextDetail(ctx, tt.qname, "A")
+		st.Expect(t, err, tt.err)
+		st.Expect(t, detail.FromCache, tt.fromCache)
+		st.Expect(t, len(detail.QueriedServers) == 0, tt.fromCache)
+		st.Expect(t, detail.Rcode, tt.rcode)
+		st.Expect(t, detail.Elapsed > 0, true)
+	}
+
+	r = NewResolver(WithDialer(failDialer{}), WithRootServers([]string{"192.0.2.250"}))
+	_, detail, err := r.ResolveContextDetail(ctx, "example.com", "A")
+	st.Reject(t, err, nil)
+	st.Expect(t, detail.FromCache, false)
+	st.Expect(t, detail.Rcode, dns.RcodeServerFailure)
+	st.Expect(t, rcodeOf(rcodeError(dns.RcodeRefused)), dns.RcodeRefused)
+}
+
+func TestResolveContextDetailMaxRecursion(t *testing.T) {
+	defer func(n int) { MaxRecursion = n }(MaxRecursion)
+	MaxRecursion = 2
+	r, _ := newTestResolver(t, testZoneRecords)
+	_, detail, _ := r.ResolveContextDetail(context.Background(), "www.example.com", "A")
+	st.Expect(t, detail.Depth, MaxRecursion+1)
+}
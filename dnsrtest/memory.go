@@ -0,0 +1,78 @@
+// Package dnsrtest provides utilities for testing code that uses dnsr.
+package dnsrtest
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// ErrNoResponse is returned by MemoryTransport for queries with no response.
+var ErrNoResponse = errors.New("dnsrtest: no response for query")
+
+// MemoryTransport is a dnsr.Transport that answers queries with canned responses,
+// without network access. The zero value is ready to use.
+// It is safe for concurrent use.
+type MemoryTransport struct {
+	mu        sync.Mutex
+	responses map[key]*dns.Msg
+	queries   []string
+}
+
+type key struct {
+	server string
+	qname  string
+	qtype  uint16
+}
+
+// Add adds a response from the name server at IP address server to queries for
+// qname and qtype, e.g. "A" or "NS". If server is empty, the response is
+// used for queries to any name server without a response of its own.
+// The ID and question of the response are set to match each query.
+func (t *MemoryTransport) Add(server, qname, qtype string, response *dns.Msg) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.responses == nil {
+		t.responses = make(map[key]*dns.Msg)
+	}
+	t.responses[key{server, fqdn(qname), dns.StringToType[strings.ToUpper(qtype)]}] = response
+}
+
+// Exchange returns a copy of the response added for query to server,
+// or ErrNoResponse if none was added.
+func (t *MemoryTransport) Exchange(ctx context.Context, server string, query *dns.Msg) (*dns.Msg, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	q := query.Question[0]
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.queries = append(t.queries, server+" "+q.Name+" "+dns.TypeToString[q.Qtype])
+	response, ok := t.responses[key{server, fqdn(q.Name), q.Qtype}]
+	if !ok {
+		response, ok = t.responses[key{"", fqdn(q.Name), q.Qtype}]
+	}
+	if !ok {
+		return nil, ErrNoResponse
+	}
+	m := response.Copy()
+	m.Id = query.Id
+	m.Response = true
+	m.Question = []dns.Question{q}
+	return m, nil
+}
+
+// Queries returns the server, name, and type of each query so far,
+// e.g. "192.0.2.1 example.com. A".
+func (t *MemoryTransport) Queries() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.queries...)
+}
+
+func fqdn(name string) string {
+	return dns.Fqdn(strings.ToLower(name))
+}
@@ -0,0 +1,50 @@
+package dnsrtest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/nbio/st"
+)
+
+func TestMemoryTransport(t *testing.T) {
+	var tr MemoryTransport
+	ctx := context.Background()
+	any := new(dns.Msg)
+	rr, _ := dns.NewRR("example.com. 300 IN A 203.0.113.1")
+	any.Answer = []dns.RR{rr}
+	one := new(dns.Msg)
+	one.Rcode = dns.RcodeNameError
+	tr.Add("", "Example.com", "A", any)
+	tr.Add("192.0.2.1", "example.com.", "a", one)
+
+	var q dns.Msg
+	q.SetQuestion("example.com.", dns.TypeA)
+	m, err := tr.Exchange(ctx, "192.0.2.2", &q)
+	st.Expect(t, err, nil)
+	st.Expect(t, m.Id, q.Id)
+	st.Expect(t, m.Response, true)
+	st.Expect(t, m.Question, q.Question)
+	st.Expect(t, len(m.Answer), 1)
+	st.Expect(t, len(any.Question), 0) // responses are copied
+
+	m, err = tr.Exchange(ctx, "192.0.2.1", &q)
+	st.Expect(t, err, nil)
+	st.Expect(t, m.Rcode, dns.RcodeNameError)
+
+	q.SetQuestion("example.com.", dns.TypeMX)
+	_, err = tr.Exchange(ctx, "192.0.2.1", &q)
+	st.Expect(t, err, ErrNoResponse)
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = tr.Exchange(ctx, "192.0.2.1", &q)
+	st.Expect(t, err, context.Canceled)
+
+	st.Expect(t, tr.Queries(), []string{
+		"192.0.2.2 example.com. A",
+		"192.0.2.1 example.com. A",
+		"192.0.2.1 example.com. MX",
+	})
+}
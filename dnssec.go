@@ -0,0 +1,474 @@
+package dnsr
+
+import (
+	"context"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// RootTrustAnchor is the DS record of the root zone key signing key
+// KSK-2017, trusted by WithDNSSEC by default.
+const RootTrustAnchor = ". IN DS 20326 8 2 E06D44B80B8F1D39A95C0B0D7C65D08458E880409BBC683457104237C7F8EC8D"
+
+var rootTrustAnchors = parseTrustAnchors(RootTrustAnchor)
+
+// parseTrustAnchors parses DS records of root zone keys, ignoring invalid records.
+func parseTrustAnchors(anchors ...string) []*dns.DS {
+	var dss []*dns.DS
+	for _, s := range anchors {
+		drr, err := dns.NewRR(s)
+		if ds, ok := drr.(*dns.DS); err == nil && ok && ds.Hdr.Name == "." {
+			dss = append(dss, ds)
+		}
+	}
+	return dss
+}
+
+// RRSIG describes the fields of an RRSIG record, other than the signature.
+type RRSIG struct {
+	TypeCovered string // type of the signed records, e.g. "A"
+	Algorithm   uint8
+	Labels      uint8
+	OrigTTL     time.Duration
+	Expiration  time.Time
+	Inception   time.Time
+	KeyTag      uint16
+	SignerName  string // lowercase and fully qualified
+}
+
+// RRSIG parses an RRSIG record. It reports false if rr is not a valid RRSIG record.
+func (rr *RR) RRSIG() (RRSIG, bool) {
+	if rr.Type != "RRSIG" {
+		return RRSIG{}, false
+	}
+	f := strings.Split(rr.Value, "\t")
+	if len(f) < 8 {
+		return RRSIG{}, false
+	}
+	alg, err1 := strconv.ParseUint(f[1], 10, 8)
+	labels, err2 := strconv.ParseUint(f[2], 10, 8)
+	ttl, err3 := strconv.ParseUint(f[3], 10, 32)
+	exp, err4 := dns.StringToTime(f[4])
+	inc, err5 := dns.StringToTime(f[5])
+	keyTag, err6 := strconv.ParseUint(f[6], 10, 16)
+	for _, err := range []error{err1, err2, err3, err4, err5, err6} {
+		if err != nil {
+			return RRSIG{}, false
+		}
+	}
+	return RRSIG{
+		TypeCovered: f[0],
+		Algorithm:   uint8(alg),
+		Labels:      uint8(labels),
+		OrigTTL:     time.Duration(ttl) * time.Second,
+		Expiration:  time.Unix(int64(exp), 0).UTC(),
+		Inception:   time.Unix(int64(inc), 0).UTC(),
+		KeyTag:      uint16(keyTag),
+		SignerName:  toLowerFQDN(f[7]),
+	}, true
+}
+
+// LookupRRSIG returns the RRSIG records for name covering records of type
+// coveredType, queried with the DNSSEC OK bit set. Signatures are not validated.
+// Use RR.RRSIG to parse the returned records, e.g. to check their expiration.
+func (r *Resolver) LookupRRSIG(ctx context.Context, name, coveredType string) (RRs, error) {
+	qname, err := r.normalize(name)
+	if err != nil {
+		return nil, err
+	}
+	rc := *r
+	rc.flights = nil
+	rc.dnssecOK = true
+	rrs, err := rc.resolveTop(ctx, qname, "RRSIG")
+	if err != nil {
+		return nil, err
+	}
+	var sigs RRs
+	for _, rr := range rrs {
+		if sig, ok := rr.RRSIG(); ok && rr.Name == qname && sig.TypeCovered == coveredType {
+			sigs = append(sigs, rr)
+		}
+	}
+	return r.returned(name, qname, sigs), nil
+}
+
+// validate validates the records of type qtype for qname resolved at depth
+// with DNSSEC, reporting whether they are secure. Only A, AAAA, and TXT
+// records are validated, along with any CNAME records followed to them.
+// Negative answers and answers from unsigned zones are reported insecure
+// without error. It returns ErrBogus if validation fails.
+func (r *Resolver) validate(ctx context.Context, qname, qtype string, depth int) (bool, error) {
+	switch qtype {
+	case "A", "AAAA", "TXT":
+	default:
+		return false, nil
+	}
+	v := &validator{r: r, ctx: ctx, depth: depth, now: time.Now(), keys: make(map[string][]*dns.DNSKEY)}
+	secure := true
+	seen := make(map[string]bool)
+	for name := qname; !seen[name]; {
+		seen[name] = true
+		rrset, sigs, err := v.set(name, qtype)
+		if err != nil {
+			return false, err
+		}
+		if len(rrset) > 0 {
+			ok, err := v.check(name, rrset, sigs)
+			return secure && ok, err
+		}
+		// The CNAME followed from name was cached with the answer
+		cset, csigs := v.cached(name, "CNAME")
+		if len(cset) == 0 {
+			return false, nil
+		}
+		ok, err := v.check(name, cset, csigs)
+		if err != nil {
+			return false, err
+		}
+		secure = secure && ok
+		cname, ok := cset[0].(*dns.CNAME)
+		if !ok {
+			return false, nil
+		}
+		name = toLowerFQDN(cname.Target)
+	}
+	return false, nil
+}
+
+// validator validates the chain of trust for a resolution,
+// remembering the validated keys of each zone.
+type validator struct {
+	r     *Resolver
+	ctx   context.Context
+	depth int
+	now   time.Time
+	keys  map[string][]*dns.DNSKEY // validated keys by zone, nil for unsigned zones
+}
+
+// set resolves the records of type rtype for name, returning them
+// with the RRSIG records covering them.
+func (v *validator) set(name, rtype string) ([]dns.RR, []*dns.RRSIG, error) {
+	_, err := v.r.resolve(v.ctx, name, rtype, v.depth)
+	if err == NXDOMAIN {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	rrset, sigs := v.cached(name, rtype)
+	return rrset, sigs, nil
+}
+
+// cached returns the cached records of type rtype owned by name,
+// with the RRSIG records covering them.
+func (v *validator) cached(name, rtype string) ([]dns.RR, []*dns.RRSIG) {
+	all, _ := v.r.cacheLookup(name, "")
+	var rrset []dns.RR
+	var sigs []*dns.RRSIG
+	seen := make(map[rrKey]bool)
+	for _, rr := range all {
+		if rr.Name != name || (rr.Type != rtype && rr.Type != "RRSIG") {
+			continue
+		}
+		k := newRRKey(rr, nil)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		raw := v.r.cache.getRaw(rr)
+		if len(raw) == 0 {
+			if drr, ok := rr.dnsRR(); ok {
+				raw = append(raw, drr)
+			}
+		}
+		for _, drr := range raw {
+			if sig, ok := drr.(*dns.RRSIG); ok {
+				if dns.TypeToString[sig.TypeCovered] == rtype {
+					sigs = append(sigs, sig)
+				}
+			} else {
+				rrset = append(rrset, drr)
+			}
+		}
+	}
+	return rrset, sigs
+}
+
+// check verifies rrset, owned by name, with sigs. Without sigs, rrset is
+// verified with the keys of the zone enclosing name, so unsigned records
+// in a signed zone are bogus.
+func (v *validator) check(name string, rrset []dns.RR, sigs []*dns.RRSIG) (bool, error) {
+	signer := v.zoneOf(name)
+	if len(sigs) > 0 {
+		signer = toLowerFQDN(sigs[0].SignerName)
+		if !dns.IsSubDomain(signer, name) {
+			return false, ErrBogus
+		}
+	}
+	return v.verify(signer, rrset, sigs)
+}
+
+// verify verifies that rrset is signed by one of sigs with a key of zone,
+// reporting false without error if zone is unsigned.
+func (v *validator) verify(zone string, rrset []dns.RR, sigs []*dns.RRSIG) (bool, error) {
+	keys, err := v.zoneKeys(zone)
+	if err != nil || keys == nil {
+		return false, err
+	}
+	for _, sig := range sigs {
+		if !sig.ValidityPeriod(v.now) || !strings.EqualFold(sig.SignerName, zone) {
+			continue
+		}
+		for _, key := range keys {
+			if key.KeyTag() == sig.KeyTag && key.Algorithm == sig.Algorithm && sig.Verify(key, rrset) == nil {
+				return true, nil
+			}
+		}
+	}
+	return false, ErrBogus
+}
+
+// zoneKeys returns the validated DNSKEY records of zone,
+// or nil if zone is unsigned.
+func (v *validator) zoneKeys(zone string) ([]*dns.DNSKEY, error) {
+	if keys, ok := v.keys[zone]; ok {
+		return keys, nil
+	}
+	if keys := v.r.dnskeys.get(zone, v.now); keys != nil {
+		v.keys[zone] = keys
+		return keys, nil
+	}
+	var anchors []*dns.DS
+	ttl := uint32(math.MaxUint32)
+	if zone == "." {
+		anchors = v.r.trustAnchors
+		if anchors == nil {
+			anchors = rootTrustAnchors
+		}
+	} else {
+		dsset, sigs, err := v.set(zone, "DS")
+		if err != nil {
+			return nil, err
+		}
+		pname, _ := parent(zone)
+		pzone := v.zoneOf(pname)
+		if len(dsset) == 0 {
+			// A zone without DS records is unsigned if its parent is,
+			// or if its signed parent proves they don’t exist
+			pkeys, err := v.zoneKeys(pzone)
+			if err != nil || pkeys == nil {
+				return nil, err
+			}
+			return nil, v.denyDS(zone, pzone)
+		}
+		signer := pzone
+		if len(sigs) > 0 {
+			signer = toLowerFQDN(sigs[0].SignerName)
+			if !dns.IsSubDomain(signer, zone) || signer == zone {
+				return nil, ErrBogus
+			}
+		}
+		secure, err := v.verify(signer, dsset, sigs)
+		if err != nil || !secure {
+			return nil, err
+		}
+		for _, drr := range dsset {
+			if ds, ok := drr.(*dns.DS); ok {
+				anchors = append(anchors, ds)
+				ttl = min(ttl, ds.Hdr.Ttl)
+			}
+		}
+	}
+
+	// The DNSKEY records must be signed by a key matching a DS record
+	keyset, sigs, err := v.set(zone, "DNSKEY")
+	if err != nil {
+		return nil, err
+	}
+	var keys, ksks []*dns.DNSKEY
+	for _, drr := range keyset {
+		key, ok := drr.(*dns.DNSKEY)
+		if !ok {
+			continue
+		}
+		keys = append(keys, key)
+		ttl = min(ttl, key.Hdr.Ttl)
+		for _, ds := range anchors {
+			if matchDS(key, ds) {
+				ksks = append(ksks, key)
+				break
+			}
+		}
+	}
+	for _, sig := range sigs {
+		if !sig.ValidityPeriod(v.now) {
+			continue
+		}
+		for _, key := range ksks {
+			if key.KeyTag() == sig.KeyTag && key.Algorithm == sig.Algorithm && sig.Verify(key, keyset) == nil {
+				v.keys[zone] = keys
+				expiry := v.now.Add(time.Duration(ttl) * time.Second)
+				if exp := time.Unix(int64(sig.Expiration), 0); exp.Before(expiry) {
+					expiry = exp
+				}
+				v.r.dnskeys.add(zone, keys, expiry)
+				return keys, nil
+			}
+		}
+	}
+	return nil, ErrBogus
+}
+
+// denyDS verifies that the signed zone pzone proves the DS records of its
+// child zone don’t exist, with the NSEC or NSEC3 records of the response
+// to the DS query, cached for zone. Returns ErrBogus if it doesn’t.
+// An NSEC3 record with the opt-out flag covering zone is accepted as proof
+// of an unsigned delegation, without a closest encloser proof.
+func (v *validator) denyDS(zone, pzone string) error {
+	all, _ := v.r.cacheLookup(zone, "")
+	owners := make(map[string]bool)
+	for _, rr := range all {
+		if rr.Type == "NSEC" || rr.Type == "NSEC3" {
+			owners[rr.Name+" "+rr.Type] = true
+		}
+	}
+	for k := range owners {
+		name, rtype, _ := strings.Cut(k, " ")
+		rrset, sigs := v.cached(name, rtype)
+		if len(rrset) == 0 {
+			continue
+		}
+		if secure, _ := v.verify(pzone, rrset, sigs); !secure {
+			continue
+		}
+		for _, drr := range rrset {
+			switch n := drr.(type) {
+			case *dns.NSEC:
+				if strings.EqualFold(n.Hdr.Name, zone) && deniesDS(n.TypeBitMap) {
+					return nil
+				}
+			case *dns.NSEC3:
+				if n.Match(zone) && deniesDS(n.TypeBitMap) {
+					return nil
+				}
+				if n.Cover(zone) && n.Flags&1 == 1 {
+					return nil
+				}
+			}
+		}
+	}
+	return ErrBogus
+}
+
+// deniesDS reports whether the type bitmap of an NSEC or NSEC3 record
+// at a delegation proves it has no DS records.
+func deniesDS(types []uint16) bool {
+	return slices.Contains(types, dns.TypeNS) && !slices.Contains(types, dns.TypeDS) && !slices.Contains(types, dns.TypeSOA)
+}
+
+// keyCache caches the validated DNSKEY records of zones across resolutions.
+type keyCache struct {
+	mu sync.Mutex
+	m  map[string]cachedKeys
+}
+
+type cachedKeys struct {
+	keys   []*dns.DNSKEY
+	expiry time.Time
+}
+
+// maxKeyCacheZones bounds the number of zones in a keyCache.
+const maxKeyCacheZones = 1000
+
+// get returns the unexpired validated keys of zone, or nil.
+func (c *keyCache) get(zone string, now time.Time) []*dns.DNSKEY {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ck, ok := c.m[zone]
+	if !ok || !now.Before(ck.expiry) {
+		return nil
+	}
+	return ck.keys
+}
+
+// add caches the validated keys of zone until expiry.
+func (c *keyCache) add(zone string, keys []*dns.DNSKEY, expiry time.Time) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = make(map[string]cachedKeys)
+	}
+	if len(c.m) >= maxKeyCacheZones {
+		now := time.Now()
+		for z, ck := range c.m {
+			if !now.Before(ck.expiry) {
+				delete(c.m, z)
+			}
+		}
+		for z := range c.m {
+			if len(c.m) < maxKeyCacheZones {
+				break
+			}
+			delete(c.m, z)
+		}
+	}
+	c.m[zone] = cachedKeys{keys, expiry}
+}
+
+// zoneOf returns the closest zone enclosing name with cached NS records, or the root.
+func (v *validator) zoneOf(name string) string {
+	for pname, ok := name, true; ok; pname, ok = parent(pname) {
+		rrs, _ := v.r.cacheLookup(pname, "NS")
+		if len(rrs) > 0 {
+			return pname
+		}
+	}
+	return "."
+}
+
+// matchDS reports whether ds is a digest of key.
+func matchDS(key *dns.DNSKEY, ds *dns.DS) bool {
+	if key.KeyTag() != ds.KeyTag || key.Algorithm != ds.Algorithm {
+		return false
+	}
+	digest := key.ToDS(ds.DigestType)
+	return digest != nil && strings.EqualFold(digest.Hdr.Name, ds.Hdr.Name) && strings.EqualFold(digest.Digest, ds.Digest)
+}
+
+// dnsRR converts rr to a dns.RR, reporting false if it cannot be parsed.
+// The value of a TXT record is split into strings of at most 255 bytes,
+// which may not match the strings of the record it was converted from.
+func (rr *RR) dnsRR() (dns.RR, bool) {
+	if rr.Type == "TXT" {
+		hdr := dns.RR_Header{Name: rr.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: uint32(rr.TTL / time.Second)}
+		txt := []string{}
+		for s := rr.Value; len(txt) == 0 || s != ""; {
+			n := min(len(s), 255)
+			txt = append(txt, s[:n])
+			s = s[n:]
+		}
+		return &dns.TXT{Hdr: hdr, Txt: txt}, true
+	}
+	if rr.Type == "CAA" {
+		flags, tag, value := rr.CAA()
+		if tag == "" {
+			return nil, false
+		}
+		hdr := dns.RR_Header{Name: rr.Name, Rrtype: dns.TypeCAA, Class: dns.ClassINET, Ttl: uint32(rr.TTL / time.Second)}
+		return &dns.CAA{Hdr: hdr, Flag: flags, Tag: tag, Value: value}, true
+	}
+	drr, err := dns.NewRR(rr.String())
+	return drr, err == nil && drr != nil
+}
@@ -0,0 +1,276 @@
+package dnsr
+
+import (
+	"context"
+	"crypto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/nbio/st"
+)
+
+// signedZoneRecords adds RRSIG records to testZoneRecords.
+var signedZoneRecords = append([]string{
+	"example.com. 300 IN RRSIG A 13 2 300 20300101000000 20240101000000 12345 Example.com. dGVzdA==",
+	"example.com. 3600 IN RRSIG SOA 13 2 3600 20300101000000 20240101000000 12345 example.com. dGVzdA==",
+}, testZoneRecords...)
+
+// signedZone is a testZone that includes RRSIG records covering answers
+// for queries with the DNSSEC OK bit set.
+func signedZone(t *testing.T) dns.Handler {
+	zone := newTestZone(t, signedZoneRecords...)
+	return dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := zone.reply(req)
+		if opt := req.IsEdns0(); opt != nil && opt.Do() && req.Question[0].Qtype != dns.TypeRRSIG {
+			for _, rr := range zone {
+				if sig, ok := rr.(*dns.RRSIG); ok && len(m.Answer) > 0 &&
+					sig.TypeCovered == m.Answer[0].Header().Rrtype && sig.Hdr.Name == m.Answer[0].Header().Name {
+					m.Answer = append(m.Answer, sig)
+				}
+			}
+		}
+		w.WriteMsg(m)
+	})
+}
+
+func TestWithDNSSECOK(t *testing.T) {
+	s := newTestServer(t, signedZone(t))
+	for _, do := range []bool{false, true} {
+		options := []Option{WithDialer(s.Dialer())}
+		if do {
+			options = append(options, WithDNSSECOK())
+		}
+		r := NewResolver(options...)
+		rrs, err := r.ResolveErr("example.com", "A")
+		st.Expect(t, err, nil)
+		st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "RRSIG" }) == 1, do)
+	}
+
+	// EDNS OPT pseudo-records in responses aren’t cached
+	zone := newTestZone(t, append(testZoneRecords, ". 86400 IN SOA a.root-servers.net. nstld.verisign-grs.com. 1 1800 900 604800 86400")...)
+	s = newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := zone.reply(req)
+		if req.IsEdns0() != nil {
+			m.SetEdns0(dns.DefaultMsgSize, true)
+		}
+		w.WriteMsg(m)
+	}))
+	r := NewResolver(WithDialer(s.Dialer()), WithDNSSECOK())
+	_, err := r.ResolveErr(".", "SOA")
+	st.Expect(t, err, nil)
+	var names []string
+	r.cache.rangeEntries(func(qname string, _ RRs) bool {
+		names = append(names, qname)
+		return true
+	})
+	st.Expect(t, names, []string{"."})
+}
+
+func TestLookupRRSIG(t *testing.T) {
+	s := newTestServer(t, signedZone(t))
+	r := NewResolver(WithDialer(s.Dialer()))
+	sigs, err := r.LookupRRSIG(context.Background(), "example.com", "A")
+	st.Expect(t, err, nil)
+	st.Assert(t, len(sigs), 1)
+	sig, ok := sigs[0].RRSIG()
+	st.Assert(t, ok, true)
+	st.Expect(t, sig, RRSIG{
+		TypeCovered: "A",
+		Algorithm:   13,
+		Labels:      2,
+		OrigTTL:     300 * time.Second,
+		Expiration:  time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC),
+		Inception:   time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		KeyTag:      12345,
+		SignerName:  "example.com.",
+	})
+
+	sigs, err = r.LookupRRSIG(context.Background(), "example.com", "MX")
+	st.Expect(t, err, nil)
+	st.Expect(t, len(sigs), 0)
+}
+
+func TestRRSIGInvalid(t *testing.T) {
+	_, ok := (&RR{Type: "A", Value: "203.0.113.1"}).RRSIG()
+	st.Expect(t, ok, false)
+	_, ok = (&RR{Type: "RRSIG", Value: "A\t13"}).RRSIG()
+	st.Expect(t, ok, false)
+}
+
+// newSignedTestServer returns a testServer answering from records like
+// testZone, with a DNSKEY record for each of zones and a DS record
+// for each zone other than the root. Answers to queries with the DNSSEC OK
+// bit set are signed with the key of the enclosing zone, if it has one.
+// Responses to DS queries at delegations to unsigned zones include an NSEC
+// record proving the DS records don’t exist, signed with the parent key.
+// Answers for names in bogus are signed with an unpublished key.
+// It also returns the DS record of the root key, for WithTrustAnchors.
+func newSignedTestServer(t *testing.T, records []string, zones []string, bogus ...string) (*testServer, string) {
+	type signer struct {
+		key  *dns.DNSKEY
+		priv crypto.Signer
+	}
+	newSigner := func(zone string) signer {
+		key := &dns.DNSKEY{
+			Hdr:       dns.RR_Header{Name: zone, Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+			Flags:     257,
+			Protocol:  3,
+			Algorithm: dns.ECDSAP256SHA256,
+		}
+		priv, err := key.Generate(256)
+		st.Assert(t, err, nil)
+		return signer{key, priv.(crypto.Signer)}
+	}
+	signers := make(map[string]signer)
+	for _, zone := range zones {
+		s := newSigner(zone)
+		signers[zone] = s
+		records = append(records, s.key.String())
+		if zone != "." {
+			records = append(records, s.key.ToDS(dns.SHA256).String())
+		}
+	}
+	forged := newSigner(".")
+	zone := newTestZone(t, records...)
+
+	// zoneOf returns the zone enclosing name
+	zoneOf := func(name string) string {
+		if soa := zone.soa(name); soa != nil {
+			return strings.ToLower(soa.Header().Name)
+		}
+		return "."
+	}
+	sign := func(rrset []dns.RR) *dns.RRSIG {
+		h := rrset[0].Header()
+		z := zoneOf(h.Name)
+		if h.Rrtype == dns.TypeDS || h.Rrtype == dns.TypeNSEC {
+			pname, _ := parent(toLowerFQDN(h.Name))
+			z = zoneOf(pname)
+		}
+		s, ok := signers[z]
+		if !ok {
+			return nil
+		}
+		sig := &dns.RRSIG{
+			Hdr:         dns.RR_Header{Name: h.Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: h.Ttl},
+			TypeCovered: h.Rrtype,
+			Algorithm:   s.key.Algorithm,
+			Labels:      uint8(dns.CountLabel(h.Name)),
+			OrigTtl:     h.Ttl,
+			Expiration:  uint32(time.Now().Add(time.Hour).Unix()),
+			Inception:   uint32(time.Now().Add(-time.Hour).Unix()),
+			KeyTag:      s.key.KeyTag(),
+			SignerName:  z,
+		}
+		priv := s.priv
+		for _, name := range bogus {
+			if strings.EqualFold(h.Name, name) {
+				priv = forged.priv
+			}
+		}
+		st.Assert(t, sig.Sign(priv, rrset), nil)
+		return sig
+	}
+	s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := zone.reply(req)
+		if opt := req.IsEdns0(); opt != nil && opt.Do() {
+			var sigs []dns.RR
+			for i := 0; i < len(m.Answer); {
+				h := m.Answer[i].Header()
+				j := i + 1
+				for j < len(m.Answer) && m.Answer[j].Header().Rrtype == h.Rrtype && strings.EqualFold(m.Answer[j].Header().Name, h.Name) {
+					j++
+				}
+				if sig := sign(m.Answer[i:j]); sig != nil {
+					sigs = append(sigs, sig)
+				}
+				i = j
+			}
+			m.Answer = append(m.Answer, sigs...)
+			if q := req.Question[0]; q.Qtype == dns.TypeDS && len(m.Answer) == 0 && m.Rcode == dns.RcodeSuccess {
+				nsec := &dns.NSEC{
+					Hdr:        dns.RR_Header{Name: q.Name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 300},
+					NextDomain: "\\000." + q.Name,
+					TypeBitMap: []uint16{dns.TypeNS, dns.TypeRRSIG, dns.TypeNSEC},
+				}
+				m.Ns = append(m.Ns, nsec)
+				if sig := sign([]dns.RR{nsec}); sig != nil {
+					m.Ns = append(m.Ns, sig)
+				}
+			}
+			m.SetEdns0(dns.DefaultMsgSize, true)
+		}
+		w.WriteMsg(m)
+	}))
+	return s, signers["."].key.ToDS(dns.SHA256).String()
+}
+
+func TestWithDNSSEC(t *testing.T) {
+	records := append([]string{
+		`example.com. 300 IN TXT "hello world" "again"`,
+		"insecure.com. 172800 IN NS ns1.example.com.",
+		"insecure.com. 3600 IN SOA ns1.example.com. hostmaster.insecure.com. 1 7200 3600 1209600 300",
+		"insecure.com. 300 IN A 203.0.113.2",
+		"bogus.example.com. 300 IN A 203.0.113.3",
+		"forged.com. 172800 IN NS ns1.example.com.",
+		"forged.com. 3600 IN SOA ns1.example.com. hostmaster.forged.com. 1 7200 3600 1209600 300",
+		"forged.com. 300 IN A 203.0.113.4",
+		"alias.example.com. 300 IN CNAME insecure.com.",
+		"spoofed.example.com. 300 IN CNAME insecure.com.",
+	}, testZoneRecords...)
+	s, anchor := newSignedTestServer(t, records, []string{".", "com.", "example.com."}, "bogus.example.com.", "forged.com.", "spoofed.example.com.")
+	r := NewResolver(WithDialer(s.Dialer()), WithDNSSEC(), WithTrustAnchors(anchor))
+	ctx := context.Background()
+	tests := []struct {
+		qname, qtype  string
+		err           error
+		authenticated bool
+	}{
+		{"example.com", "A", nil, true},
+		{"example.com", "A", nil, true}, // cached
+		{"example.com", "TXT", nil, true},
+		{"example.com", "MX", nil, false},
+		{"example.com", "NS", nil, false},
+		{"insecure.com", "A", nil, false},
+		{"bogus.example.com", "A", ErrBogus, false},
+		{"www.example.com", "A", nil, true},
+		{"alias.example.com", "A", nil, false},
+		{"spoofed.example.com", "A", ErrBogus, false}, // CNAME not signed by example.com.
+		{"forged.com", "A", ErrBogus, false},          // DS denial not signed by com.
+	}
+	for _, tt := range tests {
+		rrs, detail, err := r.ResolveContextDetail(ctx, tt.qname, tt.qtype)
+		st.Expect(t, err, tt.err)
+		st.Expect(t, detail.Authenticated, tt.authenticated)
+		if err == nil && tt.qtype != "MX" {
+			st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == tt.qtype }) > 0, true)
+		}
+	}
+	_, err := r.ResolveErr("bogus.example.com", "A")
+	st.Expect(t, err, ErrBogus)
+	st.Expect(t, r.dnskeys.get("example.com.", time.Now()) != nil, true)
+
+	// The cached root DNSKEY records don’t hide the root hints
+	r.cache.remove("com.")
+	rrs, err := r.ResolveErr("com", "NS")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "NS" }), 1)
+
+	// A cached answer is from the cache, even if validating it queries name servers
+	r.dnskeys = &keyCache{}
+	r.cache.remove("com.")
+	_, detail, err := r.ResolveContextDetail(ctx, "example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, detail.Authenticated, true)
+	st.Expect(t, detail.FromCache, true)
+	st.Expect(t, detail.Nameservers > 0, true)
+
+	// The records don’t chain to the default trust anchor
+	r = NewResolver(WithDialer(s.Dialer()), WithDNSSEC())
+	_, err = r.ResolveErr("example.com", "A")
+	st.Expect(t, err, ErrBogus)
+
+	st.Expect(t, len(parseTrustAnchors(RootTrustAnchor, "invalid", "com. IN DS 1 8 2 00")), 1)
+}
@@ -0,0 +1,87 @@
+package dnsr
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// EventType identifies the kind of an Event.
+type EventType int
+
+const (
+	EventResolveStart EventType = iota // resolution of a name not answered from the cache started
+	EventExchange                      // a query was exchanged with a name server
+	EventCNAME                         // a CNAME or alias record was followed
+	EventCacheHit                      // resolution of a name was answered from the cache
+	EventNXDOMAIN                      // a name was found not to exist
+	EventError                         // resolution of a name failed with an error other than NXDOMAIN
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventResolveStart:
+		return "resolve-start"
+	case EventExchange:
+		return "exchange"
+	case EventCNAME:
+		return "cname-follow"
+	case EventCacheHit:
+		return "cache-hit"
+	case EventNXDOMAIN:
+		return "nxdomain"
+	case EventError:
+		return "error"
+	}
+	return "unknown"
+}
+
+// Event describes resolver activity, for monitoring with WithEventChannel.
+// Fields not relevant to the event type are zero.
+type Event struct {
+	Type     EventType
+	Name     string        // name resolved or queried
+	Qtype    string        // type resolved or queried, empty for any type
+	Depth    int           // recursion depth
+	Host     string        // name server host name, for EventExchange
+	IP       string        // name server address, for EventExchange
+	Target   string        // name followed, for EventCNAME
+	Duration time.Duration // round-trip time, for EventExchange
+	Err      error         // error, for EventExchange and EventError
+}
+
+// events sends events to a channel without blocking, counting those dropped.
+type events struct {
+	ch      chan<- Event
+	dropped atomic.Uint64
+}
+
+// send sends ev, or drops it if the channel is full. It is a no-op if e is nil.
+func (e *events) send(ev Event) {
+	if e == nil {
+		return
+	}
+	select {
+	case e.ch <- ev:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// droppedCount returns the number of events dropped.
+func (e *events) droppedCount() uint64 {
+	if e == nil {
+		return 0
+	}
+	return e.dropped.Load()
+}
+
+// resolved sends an event for the result of resolving qname and qtype, if it failed.
+func (e *events) resolved(qname, qtype string, depth int, err error) {
+	switch {
+	case err == nil:
+	case err == NXDOMAIN:
+		e.send(Event{Type: EventNXDOMAIN, Name: qname, Qtype: qtype, Depth: depth})
+	default:
+		e.send(Event{Type: EventError, Name: qname, Qtype: qtype, Depth: depth, Err: err})
+	}
+}
@@ -0,0 +1,58 @@
+package dnsr
+
+import (
+	"testing"
+
+	"github.com/nbio/st"
+)
+
+func TestWithEventChannel(t *testing.T) {
+	ch := make(chan Event, 1000)
+	r, _ := newTestResolver(t, testZoneRecords, WithEventChannel(ch))
+	drain := func() map[EventType][]Event {
+		m := make(map[EventType][]Event)
+		for {
+			select {
+			case ev := <-ch:
+				m[ev.Type] = append(m[ev.Type], ev)
+			default:
+				return m
+			}
+		}
+	}
+
+	_, err := r.ResolveErr("www.example.com", "A")
+	st.Expect(t, err, nil)
+	events := drain()
+	st.Expect(t, len(events[EventResolveStart]) > 0, true)
+	st.Expect(t, events[EventResolveStart][0], Event{Type: EventResolveStart, Name: "www.example.com.", Qtype: "A", Depth: 1})
+	st.Expect(t, len(events[EventExchange]) > 0, true)
+	for _, ev := range events[EventExchange] {
+		st.Expect(t, ev.Host != "" && ev.IP != "", true)
+	}
+	st.Expect(t, len(events[EventCNAME]) > 0, true)
+	st.Expect(t, events[EventCNAME][0].Target, "example.com.")
+
+	_, err = r.ResolveErr("www.example.com", "A")
+	st.Expect(t, err, nil)
+	events = drain()
+	st.Expect(t, events[EventCacheHit], []Event{{Type: EventCacheHit, Name: "www.example.com.", Qtype: "A", Depth: 1}})
+
+	_, err = r.ResolveErr("nx.example.com", "A")
+	st.Expect(t, err, NXDOMAIN)
+	events = drain()
+	st.Expect(t, len(events[EventNXDOMAIN]) > 0, true)
+	st.Expect(t, r.Stats().DroppedEvents, uint64(0))
+
+	// A full channel doesn’t block resolution
+	r, _ = newTestResolver(t, testZoneRecords, WithEventChannel(make(chan Event)))
+	_, err = r.ResolveErr("www.example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, r.Stats().DroppedEvents > 0, true)
+}
+
+func TestEventTypeString(t *testing.T) {
+	st.Expect(t, EventResolveStart.String(), "resolve-start")
+	st.Expect(t, EventCacheHit.String(), "cache-hit")
+	st.Expect(t, EventType(-1).String(), "unknown")
+}
@@ -0,0 +1,41 @@
+package dnsr
+
+import "context"
+
+// Limiter bounds the number of concurrent exchanges with name servers.
+// A Limiter may be shared by multiple Resolvers with WithGlobalLimiter
+// to bound the total across a process, e.g. to avoid exhausting file descriptors.
+type Limiter struct {
+	sem chan struct{}
+}
+
+// NewLimiter returns a Limiter allowing up to n concurrent exchanges.
+// If n is less than 1, the Limiter allows 1 exchange at a time.
+func NewLimiter(n int) *Limiter {
+	if n < 1 {
+		n = 1
+	}
+	return &Limiter{sem: make(chan struct{}, n)}
+}
+
+// acquire blocks until an exchange may proceed or ctx is done.
+// A nil Limiter never blocks.
+func (l *Limiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release releases an exchange acquired with acquire.
+func (l *Limiter) release() {
+	if l == nil {
+		return
+	}
+	<-l.sem
+}
@@ -0,0 +1,50 @@
+package dnsr
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/nbio/st"
+)
+
+func TestWithGlobalLimiter(t *testing.T) {
+	z := newTestZone(t, testZoneRecords...)
+	var mu sync.Mutex
+	var inflight, max int
+	s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		mu.Lock()
+		if inflight++; inflight > max {
+			max = inflight
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		inflight--
+		mu.Unlock()
+		z.ServeDNS(w, req)
+	}))
+	l := NewLimiter(2)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		r := NewResolver(WithDialer(s.Dialer()), WithGlobalLimiter(l), WithRootConcurrency(4))
+		for _, qname := range []string{"example.com", "www.example.com", "ns1.example.com", "ns2.example.com"} {
+			wg.Add(1)
+			go func(qname string) {
+				defer wg.Done()
+				_, err := r.ResolveErr(qname, "A")
+				st.Expect(t, err, nil)
+			}(qname)
+		}
+	}
+	wg.Wait()
+	mu.Lock()
+	st.Expect(t, max >= 1 && max <= 2, true)
+	mu.Unlock()
+}
+
+func TestNewLimiter(t *testing.T) {
+	st.Expect(t, cap(NewLimiter(0).sem), 1)
+	st.Expect(t, cap(NewLimiter(8).sem), 8)
+}
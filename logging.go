@@ -26,6 +26,16 @@ func logMaxRecursion(qname string, qtype string, depth int) {
 		strings.Repeat("│   ", depth-1), qname, qtype, depth)
 }
 
+func logDelegationLoop(qname string, qtype string, depth int) {
+	if DebugLogger == nil {
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	fmt.Fprintf(DebugLogger, "%s Error: DELEGATION LOOP @ %s %s %d\n",
+		strings.Repeat("│   ", depth-1), qname, qtype, depth)
+}
+
 func logResolveStart(qname string, qtype string, depth int) {
 	if DebugLogger == nil {
 		return
@@ -0,0 +1,188 @@
+package dnsr
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// LookupSPF returns the SPF records published in TXT records for domain.
+// The strings of a multi-string TXT record are concatenated, per RFC 7208.
+// If domain publishes more than one SPF record, LookupSPF returns them
+// along with ErrMultipleSPF. TXT records reached via a CNAME for domain
+// are included.
+func (r *Resolver) LookupSPF(ctx context.Context, domain string) ([]string, error) {
+	qname, err := r.normalize(domain)
+	if err != nil {
+		return nil, err
+	}
+	rrs, err := r.resolveTop(ctx, qname, "TXT")
+	if err != nil {
+		return nil, err
+	}
+	var spf []string
+	seen := make(map[string]bool)
+	for _, rr := range answers(qname, "TXT", rrs) {
+		if rr.Type != "TXT" {
+			continue
+		}
+		v := rr.Value
+		if !isSPF(v) || seen[v] {
+			continue
+		}
+		seen[v] = true
+		spf = append(spf, v)
+	}
+	if len(spf) > 1 {
+		return spf, ErrMultipleSPF
+	}
+	return spf, nil
+}
+
+// isSPF reports whether the TXT record value s is an SPF version 1 record.
+func isSPF(s string) bool {
+	const v = "v=spf1"
+	return len(s) >= len(v) && strings.EqualFold(s[:len(v)], v) && (len(s) == len(v) || s[len(v)] == ' ')
+}
+
+// Exists reports whether qname exists in the DNS.
+// It returns false for nonexistent domains (NXDOMAIN), and true if any
+// response indicates the name exists, even one with no records of its own,
+// such as a delegation or empty non-terminal.
+// Other failures return an error.
+func (r *Resolver) Exists(ctx context.Context, qname string) (bool, error) {
+	_, err := r.ResolveContext(ctx, qname, "")
+	if err == NXDOMAIN {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// IsWildcard reports whether the records of type qtype for qname appear to be
+// synthesized from a wildcard, such as *.example.com. It queries a random,
+// nonexistent name under the parent of qname, and reports true if it receives
+// the same records. This is a heuristic: without DNSSEC, an explicit record
+// that matches the wildcard data is indistinguishable from a synthesized one.
+// Both queries are subject to ctx and the Resolver timeout. The random name
+// is removed from the cache afterwards, as it won’t be queried again.
+func (r *Resolver) IsWildcard(ctx context.Context, qname, qtype string) (bool, error) {
+	qname, err := r.normalize(qname)
+	if err != nil {
+		return false, err
+	}
+	pname, ok := parent(qname)
+	if !ok {
+		return false, ErrNoParent
+	}
+	rrs, err := r.resolveTop(ctx, qname, qtype)
+	if err == ErrNoData {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	want := wildcardData(qname, qtype, rrs)
+	if len(want) == 0 {
+		return false, nil
+	}
+	var label [8]byte
+	binary.BigEndian.PutUint64(label[:], r.rand.uint64())
+	probe := "dnsr-" + hex.EncodeToString(label[:]) + "." + pname
+	rrs, err = r.resolveTop(ctx, probe, qtype)
+	r.cache.remove(probe)
+	if err == NXDOMAIN || err == ErrNoData {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	got := wildcardData(probe, qtype, rrs)
+	if len(got) != len(want) {
+		return false, nil
+	}
+	for k := range want {
+		if !got[k] {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// wildcardData returns the types and values of records in rrs owned by qname
+// with type qtype or CNAME, independent of the owner name.
+func wildcardData(qname, qtype string, rrs RRs) map[[2]string]bool {
+	data := make(map[[2]string]bool)
+	for _, rr := range rrs {
+		if rr.Name == qname && (qtype == "" || rr.Type == qtype || rr.Type == "CNAME") {
+			data[[2]string{rr.Type, rr.Value}] = true
+		}
+	}
+	return data
+}
+
+// LookupAddr returns the names mapped to addr by PTR records in the reverse DNS.
+func (r *Resolver) LookupAddr(ctx context.Context, addr netip.Addr) ([]string, error) {
+	qname, err := dns.ReverseAddr(addr.String())
+	if err != nil {
+		return nil, err
+	}
+	rrs, err := r.ResolveContext(ctx, qname, "PTR")
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, rr := range rrs {
+		if rr.Type == "PTR" && rr.Name == qname {
+			names = append(names, rr.Value)
+		}
+	}
+	return names, nil
+}
+
+// LookupAddrs looks up the PTR names for each address in prefix, returning the
+// names by address. Addresses without PTR records are omitted. Up to
+// BatchConcurrency addresses are looked up at once. Prefixes with more than
+// MaxLookupAddrs addresses are refused with ErrPrefixTooLarge.
+// Names found are returned along with any errors for individual addresses.
+func (r *Resolver) LookupAddrs(ctx context.Context, prefix netip.Prefix) (map[netip.Addr][]string, error) {
+	if !prefix.IsValid() {
+		return nil, fmt.Errorf("invalid prefix: %s", prefix)
+	}
+	prefix = prefix.Masked()
+	if hostBits := prefix.Addr().BitLen() - prefix.Bits(); hostBits >= 31 || 1<<hostBits > MaxLookupAddrs {
+		return nil, ErrPrefixTooLarge
+	}
+	names := make(map[netip.Addr][]string)
+	var errs []error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := batchSem()
+	for addr := prefix.Addr(); addr.IsValid() && prefix.Contains(addr); addr = addr.Next() {
+		wg.Add(1)
+		go func(addr netip.Addr) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			ptrs, err := r.LookupAddr(ctx, addr)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && err != NXDOMAIN {
+				errs = append(errs, fmt.Errorf("%s: %w", addr, err))
+			} else if len(ptrs) > 0 {
+				names[addr] = ptrs
+			}
+		}(addr)
+	}
+	wg.Wait()
+	return names, errors.Join(errs...)
+}
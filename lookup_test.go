@@ -0,0 +1,178 @@
+package dnsr
+
+import (
+	"context"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/nbio/st"
+)
+
+func TestLookupSPF(t *testing.T) {
+	records := append([]string{
+		`example.com. 300 IN TXT "v=spf1 include:" "_spf.example.com ~all"`,
+		`example.com. 300 IN TXT "v=spf10 -all"`,
+		`example.com. 300 IN TXT "google-site-verification=abc"`,
+	}, testZoneRecords...)
+	r, _ := newTestResolver(t, records)
+	spf, err := r.LookupSPF(context.Background(), "example.com")
+	st.Expect(t, err, nil)
+	st.Expect(t, spf, []string{"v=spf1 include:_spf.example.com ~all"})
+
+	r.cache.add("example.net.", RR{Name: "example.net.", Type: "TXT", Value: "google-site-verification=abc"})
+	spf, err = r.LookupSPF(context.Background(), "example.net")
+	st.Expect(t, err, nil)
+	st.Expect(t, len(spf), 0)
+
+	r.cache.add("example.org.", RR{Name: "example.org.", Type: "TXT", Value: "v=spf1 -all"})
+	r.cache.add("example.org.", RR{Name: "example.org.", Type: "TXT", Value: "V=SPF1 +all"})
+	spf, err = r.LookupSPF(context.Background(), "example.org")
+	st.Expect(t, err, ErrMultipleSPF)
+	st.Expect(t, len(spf), 2)
+}
+
+func TestLookupSPFNames(t *testing.T) {
+	records := append([]string{
+		`example.com. 300 IN TXT "v=spf1 -all"`,
+		"alias.example.com. 300 IN CNAME example.com.",
+	}, testZoneRecords...)
+	ctx := context.Background()
+
+	r, _ := newTestResolver(t, records)
+	spf, err := r.LookupSPF(ctx, "alias.example.com")
+	st.Expect(t, err, nil)
+	st.Expect(t, spf, []string{"v=spf1 -all"})
+
+	r, _ = newTestResolver(t, records, WithReturnAsQueried())
+	spf, err = r.LookupSPF(ctx, "Example.COM")
+	st.Expect(t, err, nil)
+	st.Expect(t, spf, []string{"v=spf1 -all"})
+
+	r, _ = newTestResolver(t, records, WithNameRewrite(func(qname string) string {
+		return strings.Replace(qname, "example.net.", "example.com.", 1)
+	}))
+	spf, err = r.LookupSPF(ctx, "example.net")
+	st.Expect(t, err, nil)
+	st.Expect(t, spf, []string{"v=spf1 -all"})
+
+	_, err = r.LookupSPF(ctx, "invalid..example.com")
+	st.Expect(t, err, ErrInvalidName)
+}
+
+func TestIsSPF(t *testing.T) {
+	st.Expect(t, isSPF("v=spf1"), true)
+	st.Expect(t, isSPF("v=spf1 -all"), true)
+	st.Expect(t, isSPF("V=SPF1 -all"), true)
+	st.Expect(t, isSPF("v=spf10 -all"), false)
+	st.Expect(t, isSPF("v=spf"), false)
+	st.Expect(t, isSPF("spf2.0/pra -all"), false)
+}
+
+func TestExists(t *testing.T) {
+	records := append([]string{
+		"mx.example.com. 300 IN MX 10 mail.example.com.",
+		"a.b.example.com. 300 IN A 203.0.113.2",
+	}, testZoneRecords...)
+	r, _ := newTestResolver(t, records)
+	for _, name := range []string{"example.com", "www.example.com", "mx.example.com", "b.example.com", "a.b.example.com"} {
+		ok, err := r.Exists(context.Background(), name)
+		st.Expect(t, err, nil)
+		st.Expect(t, ok, true)
+	}
+	ok, err := r.Exists(context.Background(), "nx.example.com")
+	st.Expect(t, err, nil)
+	st.Expect(t, ok, false)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ok, err = r.Exists(ctx, "nx.example.net")
+	st.Expect(t, err, context.Canceled)
+	st.Expect(t, ok, false)
+}
+
+var testReverseRecords = []string{
+	"arpa. 172800 IN NS ns.arpa-servers.net.",
+	"ns.arpa-servers.net. 172800 IN A 192.0.2.1",
+	"arpa. 900 IN SOA ns.arpa-servers.net. hostmaster.arpa-servers.net. 1 1800 900 604800 86400",
+	"1.2.0.192.in-addr.arpa. 300 IN PTR one.example.com.",
+	"2.2.0.192.in-addr.arpa. 300 IN PTR two.example.com.",
+	"2.2.0.192.in-addr.arpa. 300 IN PTR deux.example.com.",
+}
+
+func TestLookupAddr(t *testing.T) {
+	r, _ := newTestResolver(t, testReverseRecords)
+	names, err := r.LookupAddr(context.Background(), netip.MustParseAddr("192.0.2.1"))
+	st.Expect(t, err, nil)
+	st.Expect(t, names, []string{"one.example.com."})
+	_, err = r.LookupAddr(context.Background(), netip.MustParseAddr("192.0.2.3"))
+	st.Expect(t, err, NXDOMAIN)
+}
+
+func TestLookupAddrs(t *testing.T) {
+	r, _ := newTestResolver(t, testReverseRecords)
+	names, err := r.LookupAddrs(context.Background(), netip.MustParsePrefix("192.0.2.3/29"))
+	st.Expect(t, err, nil)
+	st.Expect(t, len(names), 2)
+	st.Expect(t, names[netip.MustParseAddr("192.0.2.1")], []string{"one.example.com."})
+	st.Expect(t, len(names[netip.MustParseAddr("192.0.2.2")]), 2)
+
+	_, err = r.LookupAddrs(context.Background(), netip.MustParsePrefix("10.0.0.0/8"))
+	st.Expect(t, err, ErrPrefixTooLarge)
+	_, err = r.LookupAddrs(context.Background(), netip.MustParsePrefix("2001:db8::/32"))
+	st.Expect(t, err, ErrPrefixTooLarge)
+	_, err = r.LookupAddrs(context.Background(), netip.Prefix{})
+	st.Reject(t, err, nil)
+}
+
+func TestIsWildcard(t *testing.T) {
+	zone := newTestZone(t, append([]string{
+		"*.wild.example.com. 300 IN A 203.0.113.9",
+		"www.wild.example.com. 300 IN A 203.0.113.10",
+	}, testZoneRecords...)...)
+	s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		// Synthesize answers from the wildcard for names that don’t exist
+		m := zone.reply(req)
+		qname := req.Question[0].Name
+		if m.Rcode == dns.RcodeNameError && strings.HasSuffix(qname, ".wild.example.com.") {
+			wreq := req.Copy()
+			wreq.Question[0].Name = "*.wild.example.com."
+			m = zone.reply(wreq)
+			m.Question = req.Question
+			for i, rr := range m.Answer {
+				m.Answer[i] = dns.Copy(rr)
+				m.Answer[i].Header().Name = qname
+			}
+		}
+		w.WriteMsg(m)
+	}))
+	r := NewResolver(WithDialer(s.Dialer()))
+	ctx := context.Background()
+
+	wild, err := r.IsWildcard(ctx, "host.wild.example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, wild, true)
+
+	wild, err = r.IsWildcard(ctx, "www.wild.example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, wild, false)
+
+	wild, err = r.IsWildcard(ctx, "example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, wild, false)
+
+	_, err = r.IsWildcard(ctx, "nx.example.com", "A")
+	st.Expect(t, err, NXDOMAIN)
+
+	// Random probe names aren’t cached
+	probes := 0
+	r.Range(func(qname string, rrs RRs) bool {
+		if strings.HasPrefix(qname, "dnsr-") {
+			probes++
+		}
+		return true
+	})
+	st.Expect(t, probes, 0)
+	st.Expect(t, r.Stats().Evictions, uint64(0))
+}
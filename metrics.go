@@ -0,0 +1,53 @@
+package dnsr
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// Metrics receives counters and observations of resolver activity,
+// e.g. to export to a monitoring system such as Prometheus.
+// Methods are called synchronously, and must be safe for concurrent use.
+type Metrics interface {
+	// IncCacheHit counts a resolution answered from the cache,
+	// including cached NXDOMAIN and NODATA responses.
+	IncCacheHit()
+
+	// IncCacheMiss counts a resolution not answered from the cache.
+	IncCacheMiss()
+
+	// IncCacheEviction counts a cache entry evicted for reason.
+	IncCacheEviction(reason EvictReason)
+
+	// IncExchange counts a response with rcode from the name server at ip.
+	IncExchange(ip string, rcode int)
+
+	// ObserveExchangeLatency observes the round-trip time of a response.
+	ObserveExchangeLatency(d time.Duration)
+
+	// IncTimeout counts an exchange with the name server at ip that timed out.
+	IncTimeout(ip string)
+
+	// IncTCPRetry counts a UDP response retried over TCP, because it was
+	// truncated or its rcode was specified with WithTCPFallbackOn.
+	IncTCPRetry()
+}
+
+// nopMetrics is the default Metrics, which does nothing.
+type nopMetrics struct{}
+
+func (nopMetrics) IncCacheHit()                         {}
+func (nopMetrics) IncCacheMiss()                        {}
+func (nopMetrics) IncCacheEviction(EvictReason)         {}
+func (nopMetrics) IncExchange(string, int)              {}
+func (nopMetrics) ObserveExchangeLatency(time.Duration) {}
+func (nopMetrics) IncTimeout(string)                    {}
+func (nopMetrics) IncTCPRetry()                         {}
+
+// isTimeout reports whether err is the result of a timeout.
+func isTimeout(err error) bool {
+	var nerr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &nerr) && nerr.Timeout())
+}
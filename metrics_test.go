@@ -0,0 +1,91 @@
+package dnsr
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/nbio/st"
+)
+
+type testMetrics struct {
+	mu         sync.Mutex
+	hits       int
+	misses     int
+	evictions  int
+	exchanges  map[string]int
+	rcodes     map[int]int
+	latencies  int
+	timeouts   int
+	tcpRetries int
+}
+
+func (m *testMetrics) IncCacheHit()                         { m.inc(&m.hits) }
+func (m *testMetrics) IncCacheMiss()                        { m.inc(&m.misses) }
+func (m *testMetrics) IncCacheEviction(EvictReason)         { m.inc(&m.evictions) }
+func (m *testMetrics) ObserveExchangeLatency(time.Duration) { m.inc(&m.latencies) }
+func (m *testMetrics) IncTimeout(string)                    { m.inc(&m.timeouts) }
+func (m *testMetrics) IncTCPRetry()                         { m.inc(&m.tcpRetries) }
+
+func (m *testMetrics) inc(n *int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	*n++
+}
+
+func (m *testMetrics) IncExchange(ip string, rcode int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.exchanges == nil {
+		m.exchanges = make(map[string]int)
+		m.rcodes = make(map[int]int)
+	}
+	m.exchanges[ip]++
+	m.rcodes[rcode]++
+}
+
+func TestWithMetrics(t *testing.T) {
+	zone := newTestZone(t, testZoneRecords...)
+	s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := zone.reply(req)
+		if w.RemoteAddr().Network() == "udp" {
+			m = new(dns.Msg)
+			m.SetReply(req)
+			m.Truncated = true
+		}
+		w.WriteMsg(m)
+	}))
+	var m testMetrics
+	r := NewResolver(WithDialer(s.Dialer()), WithForwarder("192.0.2.53"), WithTCPRetry(), WithCache(1), WithMetrics(&m))
+	_, err := r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	_, err = r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	_, err = r.ResolveErr("nonexistent.example.com", "A")
+	st.Expect(t, err, NXDOMAIN)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st.Expect(t, m.hits, 1)
+	st.Expect(t, m.misses, 2)
+	st.Expect(t, m.evictions >= 1, true)
+	st.Expect(t, m.exchanges, map[string]int{"192.0.2.53": 2})
+	st.Expect(t, m.rcodes, map[int]int{dns.RcodeSuccess: 1, dns.RcodeNameError: 1})
+	st.Expect(t, m.latencies, 2)
+	st.Expect(t, m.tcpRetries, 2)
+	st.Expect(t, m.timeouts, 0)
+}
+
+func TestWithMetricsTimeout(t *testing.T) {
+	var m testMetrics
+	r, d := newTestResolver(t, testZoneRecords, WithForwarder("192.0.2.53"), WithDialTimeout(50*time.Millisecond), WithMetrics(&m))
+	d.Blackholed = func(string) bool { return true }
+	_, err := r.ResolveErr("example.com", "A")
+	st.Reject(t, err, nil)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st.Expect(t, m.timeouts, 1)
+	st.Expect(t, len(m.exchanges), 0)
+}
@@ -0,0 +1,81 @@
+package dnsr
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// QueryServer sends a single non-recursive query for qname and qtype in class qclass
+// directly to the name server at IP address server, and returns the answer records.
+// The class defaults to IN if qclass is empty. Class CH (CHAOS) is useful for
+// identifying name server software, e.g. QueryServer(ctx, ip, "version.bind", "TXT", "CH").
+// Results are not cached.
+func (r *Resolver) QueryServer(ctx context.Context, server, qname, qtype, qclass string) (RRs, error) {
+	if net.ParseIP(server) == nil {
+		return nil, ErrInvalidServer
+	}
+	dclass := uint16(dns.ClassINET)
+	if qclass != "" {
+		var ok bool
+		if dclass, ok = dns.StringToClass[strings.ToUpper(qclass)]; !ok {
+			return nil, ErrInvalidClass
+		}
+	}
+	dtype := dns.StringToType[qtype]
+	if dtype == 0 {
+		dtype = dns.TypeA
+	}
+	rmsg, err := r.queryServer(ctx, server, toLowerFQDN(qname), dtype, dclass)
+	if err != nil {
+		return nil, err
+	}
+	var rrs RRs
+	for _, drr := range rmsg.Answer {
+		if rr, ok := convertRR(drr, r.expire); ok {
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs, nil
+}
+
+// queryServer sends a single non-recursive query for a normalized qname
+// to the name server at IP address server within the Resolver timeout,
+// and returns the response. NXDOMAIN and other unsuccessful responses are errors.
+func (r *Resolver) queryServer(ctx context.Context, server, qname string, dtype, dclass uint16) (*dns.Msg, error) {
+	var qmsg dns.Msg
+	qmsg.SetQuestion(qname, dtype)
+	qmsg.Question[0].Qclass = dclass
+	qmsg.MsgHdr.RecursionDesired = false
+	if r.edns {
+		qmsg.SetEdns0(dns.DefaultMsgSize, false)
+		qmsg.IsEdns0().SetVersion(r.ednsVersion)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	client := &dns.Client{Timeout: r.timeout}
+	rmsg, _, err := r.exchangeMsg(ctx, client, qname, server, &qmsg, time.Now())
+	for err == nil && rmsg.Rcode == dns.RcodeBadVers && r.edns && qmsg.IsEdns0().Version() > 0 {
+		// Retry with a lower version, at most the highest the server supports (RFC 6891 §6.1.3)
+		opt := qmsg.IsEdns0()
+		version := opt.Version() - 1
+		if ropt := rmsg.IsEdns0(); ropt != nil && ropt.Version() < version {
+			version = ropt.Version()
+		}
+		opt.SetVersion(version)
+		rmsg, _, err = r.exchangeMsg(ctx, client, qname, server, &qmsg, time.Now())
+	}
+	if err != nil {
+		return nil, timeoutErr(err)
+	}
+	if rmsg.Rcode == dns.RcodeNameError {
+		return nil, NXDOMAIN
+	} else if rmsg.Rcode != dns.RcodeSuccess {
+		return nil, rcodeError(rmsg.Rcode)
+	}
+	return rmsg, nil
+}
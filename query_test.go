@@ -0,0 +1,98 @@
+package dnsr
+
+import (
+	"context"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/nbio/st"
+)
+
+func TestQueryServer(t *testing.T) {
+	qclass := make(chan uint16, 1)
+	s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		qclass <- req.Question[0].Qclass
+		m := new(dns.Msg)
+		m.SetReply(req)
+		if req.Question[0].Name == "version.bind." {
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: "version.bind.", Rrtype: dns.TypeTXT, Class: dns.ClassCHAOS},
+				Txt: []string{"9.18.0"},
+			})
+		} else {
+			m.Rcode = dns.RcodeNameError
+		}
+		w.WriteMsg(m)
+	}))
+	r := NewResolver(WithDialer(s.Dialer()))
+	ctx := context.Background()
+
+	rrs, err := r.QueryServer(ctx, "192.0.2.1", "VERSION.BIND", "TXT", "CH")
+	st.Expect(t, err, nil)
+	st.Expect(t, <-qclass, uint16(dns.ClassCHAOS))
+	st.Expect(t, len(rrs), 1)
+	st.Expect(t, rrs[0].Name, "version.bind.")
+	st.Expect(t, rrs[0].Value, "9.18.0")
+	st.Expect(t, r.cache.get("version.bind."), RRs(nil))
+
+	_, err = r.QueryServer(ctx, "192.0.2.1", "id.server", "TXT", "")
+	st.Expect(t, err, NXDOMAIN)
+	st.Expect(t, <-qclass, uint16(dns.ClassINET))
+
+	_, err = r.QueryServer(ctx, "192.0.2.1", "version.bind", "TXT", "XX")
+	st.Expect(t, err, ErrInvalidClass)
+	_, err = r.QueryServer(ctx, "ns1.example.com", "version.bind", "TXT", "CH")
+	st.Expect(t, err, ErrInvalidServer)
+}
+
+func TestWithEDNSVersion(t *testing.T) {
+	versions := make(chan int, 10)
+	s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(req)
+		opt := req.IsEdns0()
+		if opt == nil {
+			versions <- -1
+		} else {
+			versions <- int(opt.Version())
+			m.SetEdns0(dns.DefaultMsgSize, false)
+		}
+		if opt != nil && opt.Version() > 0 {
+			m.Rcode = dns.RcodeBadVers
+		} else {
+			m.Answer = append(m.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET},
+				Txt: []string{"hello"},
+			})
+		}
+		w.WriteMsg(m)
+	}))
+	received := func() []int {
+		var vs []int
+		for {
+			select {
+			case v := <-versions:
+				vs = append(vs, v)
+			default:
+				return vs
+			}
+		}
+	}
+	ctx := context.Background()
+	tests := []struct {
+		options  []Option
+		versions []int
+	}{
+		{nil, []int{-1}},
+		{[]Option{WithEDNSVersion(0)}, []int{0}},
+		{[]Option{WithEDNSVersion(1)}, []int{1, 0}},
+		{[]Option{WithEDNSVersion(2)}, []int{2, 0}}, // the server supports version 0
+	}
+	for _, tt := range tests {
+		r := NewResolver(append([]Option{WithDialer(s.Dialer())}, tt.options...)...)
+		rrs, err := r.QueryServer(ctx, "192.0.2.1", "example.com", "TXT", "")
+		st.Expect(t, err, nil)
+		st.Expect(t, len(rrs), 1)
+		st.Expect(t, received(), tt.versions)
+	}
+}
@@ -2,12 +2,22 @@ package dnsr
 
 import (
 	"context"
+	crand "crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"io"
+	"math/rand"
 	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/miekg/dns"
+	"golang.org/x/sync/singleflight"
 )
 
 // DNS Resolution configuration.
@@ -17,24 +27,145 @@ var (
 	MaxRecursion        = 10
 	MaxNameservers      = 2
 	MaxIPs              = 2
+	RootConcurrency     = 1
+	BatchConcurrency    = 4
+	MaxLookupAddrs      = 256
+	HappyEyeballsDelay  = 50 * time.Millisecond
+	MaxResponseSize     = 32768
 )
 
+// WarmupTLDs are the top-level domains pre-resolved by Warmup if none are specified.
+var WarmupTLDs = []string{"com", "net", "org"}
+
+// AllTypes are the record types queried by ResolveAll.
+var AllTypes = []string{
+	"A", "AAAA", "CNAME", "NS", "SOA", "MX", "TXT", "CAA", "SRV", "PTR",
+	"NAPTR", "DS", "DNSKEY", "HTTPS", "SVCB", "TLSA", "SSHFP",
+}
+
 // Resolver errors.
+// Errors other than those for timeouts and failed name servers are permanent:
+// their Temporary method, like that of net.Error, reports false.
 var (
-	NXDOMAIN = fmt.Errorf("NXDOMAIN")
+	NXDOMAIN = newError("NXDOMAIN", false)
+
+	// ErrNXDomain is NXDOMAIN, returned when a name does not exist.
+	ErrNXDomain = NXDOMAIN
+
+	// ErrNoData is returned by a Resolver created with WithNoDataError
+	// when a name exists, but has no records of the queried type.
+	ErrNoData = newError("no records of the queried type", false)
+
+	// ErrMaxRecursion matches errors from resolutions exceeding MaxRecursion.
+	// They are returned as a *MaxRecursionError, so compare them with
+	// errors.Is(err, ErrMaxRecursion), not err == ErrMaxRecursion, which no
+	// longer matches. Use errors.As to get the MaxRecursionError details.
+	ErrMaxRecursion = newError(fmt.Sprintf("maximum recursion depth reached: %d", MaxRecursion), false)
+
+	ErrMaxIPs         = newError(fmt.Sprintf("maximum name server IPs queried: %d", MaxIPs), true)
+	ErrNoARecords     = newError("no A records found for name server", false)
+	ErrNoResponse     = newError("no responses received", true)
+	ErrTimeout        = error(timeoutError{})
+	ErrNotAllowed     = newError("name not in allowlist", false)
+	ErrMultipleSPF    = newError("multiple SPF records found", false)
+	ErrDelegationLoop = newError("delegation loop detected", false)
+	ErrInvalidServer  = newError("invalid name server IP address", false)
+	ErrInvalidClass   = newError("invalid DNS class", false)
+	ErrNoParent       = newError("zone has no parent", false)
+	ErrPrefixTooLarge = newError("prefix has more than MaxLookupAddrs addresses", false)
+	ErrInvalidName    = newError("invalid domain name", false)
+	ErrPublicSuffix   = newError("name is a public suffix", false)
 
-	ErrMaxRecursion = fmt.Errorf("maximum recursion depth reached: %d", MaxRecursion)
-	ErrMaxIPs       = fmt.Errorf("maximum name server IPs queried: %d", MaxIPs)
-	ErrNoARecords   = fmt.Errorf("no A records found for name server")
-	ErrNoResponse   = fmt.Errorf("no responses received")
-	ErrTimeout      = fmt.Errorf("timeout expired") // TODO: Timeouter interface? e.g. func (e) Timeout() bool { return true }
+	ErrCNAMEAndOtherData = newError("CNAME and other data at the same name", false)
+	ErrRecursedAnswer    = newError("recursive answer from name server", false)
+	ErrResponseTooLarge  = newError("response exceeds maximum size", true)
+	ErrNotAuthoritative  = newError("name server not authoritative for zone", false)
+	ErrNoDelegation      = newError("zone not delegated by parent zone", false)
+	ErrBogus             = newError("DNSSEC validation failed", false)
 )
 
+// resolverError is a Resolver error that reports whether it is temporary.
+type resolverError struct {
+	s         string
+	temporary bool
+}
+
+func newError(s string, temporary bool) error {
+	return &resolverError{s, temporary}
+}
+
+func (e *resolverError) Error() string   { return e.s }
+func (e *resolverError) Timeout() bool   { return false }
+func (e *resolverError) Temporary() bool { return e.temporary }
+
+// rcodeError returns the error for a response with rcode.
+// Server failures are temporary.
+func rcodeError(rcode int) error {
+	return newError(dns.RcodeToString[rcode], rcode == dns.RcodeServerFailure)
+}
+
+// timeoutError is returned when a resolution runs out of time, either from the
+// Resolver timeout or a context deadline. It wraps context.DeadlineExceeded.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout expired" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+func (timeoutError) Unwrap() error   { return context.DeadlineExceeded }
+
+// MaxRecursionError is returned when a resolution exceeds MaxRecursion.
+// It matches ErrMaxRecursion with errors.Is.
+type MaxRecursionError struct {
+	Qname string // name being resolved when the limit was reached
+	Qtype string
+
+	// Chain lists the resolutions that led to Qname and Qtype,
+	// outermost first, e.g. "www.example.com. A".
+	Chain []string
+}
+
+func (e *MaxRecursionError) Error() string {
+	return fmt.Sprintf("%s: %s %s", ErrMaxRecursion, e.Qname, e.Qtype)
+}
+
+func (e *MaxRecursionError) Is(target error) bool { return target == ErrMaxRecursion }
+func (e *MaxRecursionError) Timeout() bool        { return false }
+func (e *MaxRecursionError) Temporary() bool      { return false }
+
+// temporary reports whether err is a temporary error, such as a timeout
+// or a failure to receive responses, which may succeed if retried.
+func temporary(err error) bool {
+	var te interface{ Temporary() bool }
+	return errors.As(err, &te) && te.Temporary()
+}
+
+// timeoutErr normalizes context deadline errors to ErrTimeout.
+func timeoutErr(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrTimeout
+	}
+	return err
+}
+
+// isContextErr reports whether err is from a canceled or expired context,
+// such as a dial exceeding the dial timeout.
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
 // A ContextDialer implements the DialContext method, e.g. net.Dialer.
 type ContextDialer interface {
 	DialContext(ctx context.Context, network, addr string) (net.Conn, error)
 }
 
+// A Transport exchanges DNS messages with name servers.
+// Package dnsrtest provides a Transport for testing.
+type Transport interface {
+	// Exchange sends query to the name server at IP address server,
+	// and returns the response.
+	Exchange(ctx context.Context, server string, query *dns.Msg) (*dns.Msg, error)
+}
+
 // Option specifies a configuration option for a Resolver.
 type Option func(*Resolver)
 
@@ -52,6 +183,73 @@ func WithDialer(d ContextDialer) Option {
 	}
 }
 
+// WithDialTimeout limits the time to establish each connection to a name server
+// to d, independent of the query timeout, so a name server address that doesn’t
+// accept connections fails quickly and the next address is tried.
+func WithDialTimeout(d time.Duration) Option {
+	return func(r *Resolver) {
+		r.dialTimeout = d
+	}
+}
+
+// WithEDNSVersion specifies that queries sent directly to a name server, with
+// QueryServer and the checks built on it, include an EDNS(0) OPT record with
+// EDNS version. If the name server responds with BADVERS, the query is retried
+// with a lower version, down to 0. This is useful for EDNS conformance testing.
+func WithEDNSVersion(version uint8) Option {
+	return func(r *Resolver) {
+		r.edns = true
+		r.ednsVersion = version
+	}
+}
+
+// WithEDNSPadding specifies that queries sent over TCP are padded with the
+// EDNS(0) Padding option (RFC 7830) to a multiple of 128 bytes (RFC 8467),
+// to obscure the size of queries.
+func WithEDNSPadding() Option {
+	return func(r *Resolver) {
+		r.ednsPadding = true
+	}
+}
+
+// WithEDNSKeepalive specifies that queries sent over TCP include the
+// EDNS(0) TCP Keepalive option (RFC 7828), to signal support for
+// keeping connections open.
+func WithEDNSKeepalive() Option {
+	return func(r *Resolver) {
+		r.ednsKeepalive = true
+	}
+}
+
+// WithForwarder specifies that the Resolver acts as a caching stub resolver,
+// sending recursive queries to the name server at IP address addr, such as
+// a public or corporate resolver, rather than resolving iteratively from the root.
+// The cache, expiry, TCP, and timeout options apply as for iterative resolution.
+func WithForwarder(addr string) Option {
+	return func(r *Resolver) {
+		r.forwarder = addr
+	}
+}
+
+// WithTLS specifies that name servers are queried with DNS over TLS (RFC 7858)
+// on port 853 using config, rather than over UDP or TCP on port 53.
+// Few authoritative name servers support TLS, so this is most useful with name
+// servers that resolve recursively, such as a forwarder. If config does not
+// specify a ServerName, certificates are verified against the name server IP address.
+func WithTLS(config *tls.Config) Option {
+	return func(r *Resolver) {
+		r.tls = config
+	}
+}
+
+// WithTransport specifies a Transport for exchanging messages with name servers,
+// replacing the network. The dialer and TCP options are ignored.
+func WithTransport(t Transport) Option {
+	return func(r *Resolver) {
+		r.transport = t
+	}
+}
+
 // WithExpiry specifies that the Resolver will delete stale cache entries.
 func WithExpiry() Option {
 	return func(r *Resolver) {
@@ -67,6 +265,205 @@ func WithTimeout(timeout time.Duration) Option {
 	}
 }
 
+// WithStaleDelegation specifies that expired name server (NS) and address records
+// used to find the name servers for a query may be used for up to grace after they
+// expire, so only the answer itself is fetched again.
+// It has no effect unless the Resolver expires records (WithExpiry).
+func WithStaleDelegation(grace time.Duration) Option {
+	return func(r *Resolver) {
+		r.staleGrace = grace
+	}
+}
+
+// WithMinimalResponse specifies that resolution stops at the first answer
+// from a responding name server, trading completeness for throughput in bulk
+// existence checks. CNAME targets are not resolved, the name servers for the
+// queried name are not included in results, and addresses are not resolved
+// for name servers returned without glue by NS queries.
+func WithMinimalResponse() Option {
+	return func(r *Resolver) {
+		r.minimal = true
+	}
+}
+
+// WithMaxTXTBytes specifies that TXT record values longer than n bytes
+// are returned truncated to n bytes followed by "...", limiting the data
+// callers ingest from zones with enormous TXT records. Records are cached
+// whole, so distinct records sharing a prefix remain distinct, and
+// ResolveRaw returns them whole. The default of 0 means no limit.
+func WithMaxTXTBytes(n int) Option {
+	return func(r *Resolver) {
+		r.maxTXT = n
+	}
+}
+
+// WithRawRecords specifies that the cache retains the dns.RR each cached
+// record was converted from, so ResolveRaw returns records as received,
+// e.g. with TXT string boundaries intact, at the cost of memory.
+// Records are always retained with WithDNSSEC, which needs them to verify
+// signatures.
+func WithRawRecords() Option {
+	return func(r *Resolver) {
+		r.rawRecords = true
+	}
+}
+
+// WithResolutionRetry specifies that resolutions failing with a temporary
+// error, such as ErrNoResponse or a name server failure, are retried from the
+// start up to attempts times, within the timeout or context deadline.
+// Records cached by the failed attempt are reused.
+// Permanent errors, such as NXDOMAIN or ErrInvalidName, are not retried.
+func WithResolutionRetry(attempts int) Option {
+	return func(r *Resolver) {
+		r.retries = attempts
+	}
+}
+
+// WithStrictResolve specifies that Resolve returns nil only if resolution fails.
+// Successful resolutions without records return an empty, non-nil slice,
+// as for nonexistent domains.
+func WithStrictResolve() Option {
+	return func(r *Resolver) {
+		r.strictResolve = true
+	}
+}
+
+// WithRandSource specifies a source of randomness for the order of records,
+// which determines the order in which name servers and their addresses are
+// queried, making resolutions reproducible given the same responses.
+// Name servers are queried in parallel, so which answers first may still vary.
+// By default, a source seeded from crypto/rand is used.
+func WithRandSource(src rand.Source) Option {
+	return func(r *Resolver) {
+		r.rand = &lockedRand{r: rand.New(src)}
+	}
+}
+
+// WithRoundRobin specifies that records are returned in round-robin order:
+// each set of records of the same name and type is sorted, then rotated by
+// one more position on each resolution of the same name, distributing load
+// evenly across addresses.
+// It takes precedence over WithRandSource for cached results.
+func WithRoundRobin() Option {
+	return func(r *Resolver) {
+		r.roundRobin = true
+	}
+}
+
+// WithIPv6 specifies that name servers are also queried at their IPv6 (AAAA)
+// addresses, interleaved with their IPv4 addresses. MaxIPs applies across
+// both address families combined. By default, only IPv4 addresses are queried.
+// It is equivalent to WithAddressFamily(DualStack).
+func WithIPv6() Option {
+	return WithAddressFamily(DualStack)
+}
+
+// AddressFamily specifies the address families at which name servers are queried.
+type AddressFamily int
+
+const (
+	IPv4Only  AddressFamily = iota // query name servers at IPv4 (A) addresses only; the default
+	IPv6Only                       // query name servers at IPv6 (AAAA) addresses only
+	DualStack                      // query name servers at both, interleaved
+)
+
+func (fam AddressFamily) String() string {
+	switch fam {
+	case IPv4Only:
+		return "IPv4Only"
+	case IPv6Only:
+		return "IPv6Only"
+	case DualStack:
+		return "DualStack"
+	}
+	return "unknown"
+}
+
+// WithAddressFamily specifies the address families at which name servers are
+// queried. Addresses of other families are never dialed, e.g. on hosts without
+// IPv6 connectivity, or IPv6-only hosts that can’t reach IPv4 glue addresses.
+func WithAddressFamily(fam AddressFamily) Option {
+	return func(r *Resolver) {
+		r.family = fam
+	}
+}
+
+// WithHappyEyeballs specifies that name servers with both IPv4 and IPv6
+// addresses are queried at an IPv6 address first, then, if no response is
+// received within HappyEyeballsDelay, at an IPv4 address in parallel,
+// using whichever answers first. It has no effect unless the address family
+// is DualStack (WithIPv6).
+func WithHappyEyeballs() Option {
+	return func(r *Resolver) {
+		r.happyEyeballs = true
+	}
+}
+
+// WithEventChannel specifies a channel to receive events describing resolver
+// activity, for real-time monitoring. Events are sent without blocking:
+// if ch is full, the event is dropped and counted in Stats.DroppedEvents.
+func WithEventChannel(ch chan<- Event) Option {
+	return func(r *Resolver) {
+		r.events = &events{ch: ch}
+	}
+}
+
+// WithMetrics specifies m to receive counters and observations of resolver
+// activity, such as cache hits and misses and exchanges with name servers.
+func WithMetrics(m Metrics) Option {
+	return func(r *Resolver) {
+		r.metrics = m
+	}
+}
+
+// WithDNSSECOK specifies that queries set the DNSSEC OK (DO) bit,
+// so name servers for signed zones return RRSIG records with answers.
+// Signatures are not validated.
+func WithDNSSECOK() Option {
+	return func(r *Resolver) {
+		r.dnssecOK = true
+	}
+}
+
+// WithDNSSEC specifies that resolutions of A, AAAA, and TXT records are
+// validated with DNSSEC, following the chain of trust from the root trust
+// anchor (RootTrustAnchor, unless specified with WithTrustAnchors).
+// CNAME records followed to the answer are validated too.
+// Resolution fails with ErrBogus if validation fails, including if a zone
+// without DS records has a signed parent that doesn’t prove their absence.
+// Detail.Authenticated reports whether the answer was validated. Answers
+// from unsigned zones and negative answers are returned without validation,
+// so callers requiring validated answers must check Detail.Authenticated.
+// Validated zone keys are cached until they expire.
+// It implies WithDNSSECOK.
+func WithDNSSEC() Option {
+	return func(r *Resolver) {
+		r.dnssecOK = true
+		r.dnssec = true
+	}
+}
+
+// WithTrustAnchors specifies the DS records of the root zone keys trusted
+// by WithDNSSEC, in zone file format, replacing RootTrustAnchor.
+// Invalid records are ignored.
+func WithTrustAnchors(anchors ...string) Option {
+	return func(r *Resolver) {
+		r.trustAnchors = parseTrustAnchors(anchors...)
+	}
+}
+
+// WithMaxResponseSize specifies the maximum size in bytes of responses accepted
+// from name servers, limiting memory used by malicious or misconfigured servers.
+// Larger responses are rejected with ErrResponseTooLarge as they are read,
+// and another name server address is tried. Responses from a Transport are
+// measured as packed with name compression, as name servers send them. The default is MaxResponseSize.
+// A size of 0 accepts responses up to the DNS maximum of 65535 bytes.
+func WithMaxResponseSize(bytes int) Option {
+	return func(r *Resolver) {
+		r.maxResponse = bytes
+	}
+}
+
 // WithTCPRetry specifies that requests should be retried with TCP if responses
 // are truncated. The retry must still complete within the timeout or context deadline.
 func WithTCPRetry() Option {
@@ -75,25 +472,342 @@ func WithTCPRetry() Option {
 	}
 }
 
+// WithTCPFallbackOn specifies that requests should be retried with TCP if
+// UDP responses have one of rcodes, such as dns.RcodeServerFailure from
+// servers behind networks that mangle large UDP responses. It is independent
+// of WithTCPRetry, which retries truncated responses.
+func WithTCPFallbackOn(rcodes ...int) Option {
+	return func(r *Resolver) {
+		r.tcpRcodes = rcodes
+	}
+}
+
+// WithTCPOnly specifies that all queries are sent over TCP rather than UDP.
+// Queries fall back to TCP if the dialer fails to dial UDP, but this avoids the
+// failed dial with dialers that only support TCP, such as a SOCKS5 proxy dialer
+// from golang.org/x/net/proxy.
+func WithTCPOnly() Option {
+	return func(r *Resolver) {
+		r.tcpOnly = true
+	}
+}
+
+// WithAnswerOnly specifies that resolution results should only include records
+// of the queried type for the queried name, and any CNAME records followed to reach them,
+// excluding delegation NS and glue records.
+func WithAnswerOnly() Option {
+	return func(r *Resolver) {
+		r.answerOnly = true
+	}
+}
+
+// WithoutSOA specifies that resolution results exclude SOA records,
+// unless qtype is "SOA". SOA records are still cached for internal use,
+// and WithIncludeNegativeSOA still adds them to NXDOMAIN and NODATA results.
+func WithoutSOA() Option {
+	return func(r *Resolver) {
+		r.withoutSOA = true
+	}
+}
+
+// WithoutGlue specifies that resolution results exclude glue: A and AAAA
+// records for the name servers in NS records of the results, other than
+// records for the queried name. Unlike WithAnswerOnly, the NS records are kept.
+func WithoutGlue() Option {
+	return func(r *Resolver) {
+		r.withoutGlue = true
+	}
+}
+
+// WithRootConcurrency limits the number of simultaneous queries
+// to root and top-level domain name servers to n.
+// The default value is RootConcurrency.
+func WithRootConcurrency(n int) Option {
+	return func(r *Resolver) {
+		r.rootConcurrency = n
+	}
+}
+
+// WithGlobalLimiter specifies a Limiter bounding concurrent exchanges with
+// name servers. Share a Limiter among Resolvers to bound the total across them.
+func WithGlobalLimiter(l *Limiter) Option {
+	return func(r *Resolver) {
+		r.limiter = l
+	}
+}
+
+// WithEvictionCallback specifies a function called for each cache entry evicted,
+// with the reason for its eviction. It is called after the cache is unlocked,
+// possibly concurrently from multiple goroutines.
+func WithEvictionCallback(fn func(qname string, reason EvictReason)) Option {
+	return func(r *Resolver) {
+		r.onEvict = fn
+	}
+}
+
+// WithNameserverStats enables counting the successes, failures, and timeouts
+// of exchanges with each name server, reported by NameserverStats.
+// Up to max name servers are tracked, discarding the least recently used.
+// If max <= 0, DefaultNameserverStats is used.
+func WithNameserverStats(max int) Option {
+	return func(r *Resolver) {
+		r.nsStats = newNSStats(max)
+	}
+}
+
+// WithCacheNoData specifies whether the Resolver caches NODATA responses,
+// where a name exists but has no records of the queried type.
+// NXDOMAIN responses are always cached. The default value is true.
+func WithCacheNoData(cache bool) Option {
+	return func(r *Resolver) {
+		r.cacheNoData = cache
+	}
+}
+
+// WithRootServers specifies the IP addresses of the root name servers,
+// replacing the embedded root hints. Invalid addresses are ignored.
+// This is useful for testing against a local DNS hierarchy.
+// IPv6 addresses are unused unless WithAddressFamily specifies IPv6Only or
+// DualStack, as the default family is IPv4Only.
+func WithRootServers(addrs []string) Option {
+	return func(r *Resolver) {
+		r.root = newRootServersCache(addrs)
+	}
+}
+
+// WithRootHints specifies root hints in the BIND named.root format,
+// read from hints, replacing the embedded root hints.
+// If the hints cannot be read or parsed, resolutions fail with the parse error.
+func WithRootHints(hints io.Reader) Option {
+	return func(r *Resolver) {
+		r.root, r.rootErr = parseRootHints(hints)
+	}
+}
+
+// WithPort specifies the port at which name servers are queried,
+// rather than 53, or 853 with WithTLS. This is useful for testing
+// against DNS servers listening on alternate ports.
+func WithPort(port int) Option {
+	return func(r *Resolver) {
+		r.port = port
+	}
+}
+
+// WithServerPort specifies the port at which the name server at IP address ip
+// is queried, overriding WithPort for that name server. It may be specified
+// more than once, for different name servers.
+func WithServerPort(ip string, port int) Option {
+	return func(r *Resolver) {
+		if r.serverPorts == nil {
+			r.serverPorts = make(map[string]int)
+		}
+		r.serverPorts[ip] = port
+	}
+}
+
+// WithAllowlist restricts resolution to the specified names and their subdomains.
+// Queries for any other name fail with ErrNotAllowed without touching the network.
+func WithAllowlist(names []string) Option {
+	return func(r *Resolver) {
+		r.allowlist = make([]string, 0, len(names))
+		for _, name := range names {
+			r.allowlist = append(r.allowlist, toLowerFQDN(name))
+		}
+	}
+}
+
+// WithIncludeNegativeSOA specifies that NXDOMAIN and NODATA results include the
+// SOA record of the enclosing zone, if known, so callers can cache negative answers
+// for the SOA minimum TTL. NXDOMAIN results return these records with the NXDOMAIN error.
+func WithIncludeNegativeSOA() Option {
+	return func(r *Resolver) {
+		r.negativeSOA = true
+	}
+}
+
+// WithStrictCNAME specifies that resolution fails with ErrCNAMEAndOtherData
+// if a name has a CNAME record alongside other records, indicating a broken zone.
+// By default, such records are returned as received.
+func WithStrictCNAME() Option {
+	return func(r *Resolver) {
+		r.strictCNAME = true
+	}
+}
+
+// WithNoDataError specifies that resolution fails with ErrNoData if the
+// queried name exists, but has no records of the queried type (NODATA).
+// By default, such resolutions return no answer records and a nil error,
+// as do those answered only by non-authoritative name servers.
+func WithNoDataError() Option {
+	return func(r *Resolver) {
+		r.noDataErr = true
+	}
+}
+
+// WithNameRewrite specifies a function that rewrites each queried name,
+// e.g. to map "*.internal." to "*.corp.example.com.". The function receives
+// a lowercase, fully-qualified name and must return a valid domain name,
+// or resolution fails with ErrInvalidName. The rewritten name is resolved
+// and cached, and is subject to the allowlist.
+func WithNameRewrite(fn func(qname string) string) Option {
+	return func(r *Resolver) {
+		r.rewrite = fn
+	}
+}
+
+// WithReturnAsQueried specifies that returned records for the queried name
+// have the name exactly as passed by the caller, rather than the normalized
+// lowercase, fully-qualified form used for other names. Normalization is
+// unaffected, so "example.com", "example.com." and "Example.COM" share cache entries.
+func WithReturnAsQueried() Option {
+	return func(r *Resolver) {
+		r.returnAsQueried = true
+	}
+}
+
+// WithRejectRecursedAnswers specifies that answers with recursion available (RA)
+// and without the authoritative (AA) flag are rejected, since the Resolver doesn’t
+// request recursion. Such answers come from forwarders masquerading as authoritative
+// name servers. Other name servers are tried instead.
+// It has no effect with WithForwarder, which requests recursion.
+func WithRejectRecursedAnswers() Option {
+	return func(r *Resolver) {
+		r.rejectRecursed = true
+	}
+}
+
+// WithQueryModifier specifies a function to customize each query message
+// before it is sent, for example to set flags or add EDNS options.
+// It is called for every exchange with a name server, so it must be fast and safe
+// for concurrent use. It must not change the question, since responses are cached
+// under the name and type resolved.
+func WithQueryModifier(fn func(*dns.Msg)) Option {
+	return func(r *Resolver) {
+		r.queryModifier = fn
+	}
+}
+
+// CallOption specifies a configuration option for a single resolution,
+// overriding the Resolver configuration.
+type CallOption func(*Resolver)
+
+// CallTimeout overrides the Resolver timeout for a single resolution.
+func CallTimeout(timeout time.Duration) CallOption {
+	return func(r *Resolver) {
+		r.timeout = timeout
+	}
+}
+
+// CallAnswerOnly overrides whether results exclude delegation records
+// for a single resolution. See WithAnswerOnly.
+func CallAnswerOnly(answerOnly bool) CallOption {
+	return func(r *Resolver) {
+		r.answerOnly = answerOnly
+	}
+}
+
+// CallTCPRetry overrides whether truncated responses are retried with TCP
+// for a single resolution. See WithTCPRetry.
+func CallTCPRetry(tcpRetry bool) CallOption {
+	return func(r *Resolver) {
+		r.tcpRetry = tcpRetry
+	}
+}
+
 // Resolver implements a primitive, non-recursive, caching DNS resolver.
 type Resolver struct {
-	dialer   ContextDialer
-	timeout  time.Duration
-	cache    *cache
-	capacity int
-	expire   bool
-	tcpRetry bool
+	dialer      ContextDialer
+	timeout     time.Duration
+	cache       *cache
+	root        *cache
+	capacity    int
+	expire      bool
+	tcpRetry    bool
+	tcpOnly     bool
+	allowlist   []string
+	answerOnly  bool
+	cacheNoData bool
+	negativeSOA bool
+	strictCNAME bool
+	noDataErr   bool
+
+	rootConcurrency int
+	rootSem         chan struct{}
+	stats           *stats
+	returnAsQueried bool
+	family          AddressFamily
+	rejectRecursed  bool
+	queryModifier   func(*dns.Msg)
+	transport       Transport
+	staleGrace      time.Duration
+	minimal         bool
+	tcpRcodes       []int
+	maxTXT          int
+	rawRecords      bool
+	limiter         *Limiter
+	rewrite         func(qname string) string
+	onEvict         func(qname string, reason EvictReason)
+	nsStats         *nsStats
+	retries         int
+	strictResolve   bool
+	rand            *lockedRand
+	dnssecOK        bool
+	dnssec          bool
+	trustAnchors    []*dns.DS
+	dnskeys         *keyCache
+	maxResponse     int
+	withoutSOA      bool
+	dialTimeout     time.Duration
+	ednsPadding     bool
+	ednsKeepalive   bool
+	roundRobin      bool
+	events          *events
+	happyEyeballs   bool
+	withoutGlue     bool
+	edns            bool
+	ednsVersion     uint8
+	tls             *tls.Config
+	forwarder       string
+	port            int
+	serverPorts     map[string]int
+	rootErr         error
+	flights         *singleflight.Group // nil for copies with per-call options
+	metrics         Metrics
 }
 
 // NewResolver returns an initialized Resolver with options.
-// By default, the returned Resolver will have cache capacity 0
-// and the default network timeout (Timeout).
+// By default, the returned Resolver will have cache capacity 0,
+// the default network timeout (Timeout), and the default root concurrency (RootConcurrency).
 func NewResolver(options ...Option) *Resolver {
-	r := &Resolver{timeout: Timeout}
+	r := &Resolver{timeout: Timeout, rootConcurrency: RootConcurrency, cacheNoData: true, maxResponse: MaxResponseSize}
 	for _, o := range options {
 		o(r)
 	}
+	if r.rootConcurrency <= 0 {
+		r.rootConcurrency = RootConcurrency
+	}
+	if r.rand == nil {
+		r.rand = newLockedRand()
+	}
 	r.cache = newCache(r.capacity, r.expire)
+	r.cache.onEvict = r.onEvict
+	if r.metrics == nil {
+		r.metrics = nopMetrics{}
+	} else {
+		r.cache.onEvict = func(qname string, reason EvictReason) {
+			r.metrics.IncCacheEviction(reason)
+			if r.onEvict != nil {
+				r.onEvict(qname, reason)
+			}
+		}
+	}
+	if r.root == nil {
+		r.root = rootCache
+	}
+	r.rootSem = make(chan struct{}, r.rootConcurrency)
+	r.flights = &singleflight.Group{}
+	r.dnskeys = &keyCache{}
+	r.stats = newStats()
 	return r
 }
 
@@ -123,6 +837,10 @@ func NewExpiringWithTimeout(cap int, timeout time.Duration) *Resolver {
 
 // Resolve calls ResolveErr to find DNS records of type qtype for the domain qname.
 // For nonexistent domains (NXDOMAIN), it will return an empty, non-nil slice.
+// If resolution fails, e.g. from a timeout, it returns nil.
+// Resolutions that succeed without records may also return nil,
+// so failures are indistinguishable from empty results, unless the Resolver
+// was created with WithStrictResolve. Use ResolveErr to handle errors.
 func (r *Resolver) Resolve(qname, qtype string) RRs {
 	rrs, err := r.ResolveErr(qname, qtype)
 	if err == NXDOMAIN {
@@ -131,6 +849,9 @@ func (r *Resolver) Resolve(qname, qtype string) RRs {
 	if err != nil {
 		return nil
 	}
+	if rrs == nil && r.strictResolve {
+		return emptyRRs
+	}
 	return rrs
 }
 
@@ -139,9 +860,53 @@ func (r *Resolver) Resolve(qname, qtype string) RRs {
 // Specify an empty string in qtype to receive any DNS records found
 // (currently A, AAAA, NS, CNAME, SOA, and TXT).
 func (r *Resolver) ResolveErr(qname, qtype string) (RRs, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
-	defer cancel()
-	return r.resolve(ctx, toLowerFQDN(qname), qtype, 0)
+	query := qname
+	qname, err := r.normalize(qname)
+	if err != nil {
+		return nil, err
+	}
+	if r.rootErr != nil {
+		return nil, r.rootErr
+	}
+	// Fast path: skip the timeout context when the cache can answer,
+	// unless the answer must be validated.
+	var rrs RRs
+	if !r.dnssec {
+		rrs, err = r.cacheLookup(qname, qtype)
+	}
+	if rrs != nil || err != nil {
+		r.metrics.IncCacheHit()
+		r.stats.addDepth(1)
+		if err == nil {
+			r.events.send(Event{Type: EventCacheHit, Name: qname, Qtype: qtype, Depth: 1})
+		}
+		r.events.resolved(qname, qtype, 1, err)
+		rrs, err = r.results(qname, qtype, rrs, err)
+	} else {
+		rrs, err = r.resolveTop(context.Background(), qname, qtype)
+	}
+	return r.returned(query, qname, rrs), err
+}
+
+// ResolveCached returns the cached DNS records of type qtype for the domain qname,
+// from the Resolver cache or the root hints, without any network I/O.
+// It reports whether the cache had an answer for qname and qtype. Names cached
+// as nonexistent (NXDOMAIN) or without records of qtype (NODATA) are hits
+// with no records. Expired records are ignored if the Resolver expires records.
+func (r *Resolver) ResolveCached(qname, qtype string) (RRs, bool) {
+	query := qname
+	qname, err := r.normalize(qname)
+	if err != nil {
+		return nil, false
+	}
+	rrs, err := r.cacheLookup(qname, qtype)
+	if err == NXDOMAIN {
+		return nil, true
+	}
+	if rrs == nil {
+		return nil, false
+	}
+	return r.returned(query, qname, rrs), true
 }
 
 // ResolveCtx finds DNS records of type qtype for the domain qname using
@@ -161,54 +926,642 @@ func (r *Resolver) ResolveCtx(ctx context.Context, qname, qtype string) (RRs, er
 // For nonexistent domains, it will return an NXDOMAIN error.
 // Specify an empty string in qtype to receive any DNS records found
 // (currently A, AAAA, NS, CNAME, SOA, and TXT).
+// If ctx is already done, ResolveContext fails fast with ctx.Err(), or ErrTimeout
+// if its deadline has passed, without consulting the cache or the network.
 func (r *Resolver) ResolveContext(ctx context.Context, qname, qtype string) (RRs, error) {
+	query := qname
+	qname, err := r.normalize(qname)
+	if err != nil {
+		return nil, err
+	}
+	rrs, err := r.resolveTop(ctx, qname, qtype)
+	return r.returned(query, qname, rrs), err
+}
+
+// ResolveOpts is like ResolveContext, with options that override
+// the Resolver configuration for this resolution only.
+// The Resolver cache is shared with other resolutions.
+func (r *Resolver) ResolveOpts(ctx context.Context, qname, qtype string, opts ...CallOption) (RRs, error) {
+	if len(opts) == 0 {
+		return r.ResolveContext(ctx, qname, qtype)
+	}
+	rc := *r
+	rc.flights = nil
+	for _, o := range opts {
+		o(&rc)
+	}
+	return rc.ResolveContext(ctx, qname, qtype)
+}
+
+// ResolveZone resolves records of type qtype for qname as ResolveContext does,
+// and writes them to w in zone file format with RRs.WriteZone.
+// Records are written only once resolution succeeds, so nothing is written on error.
+// If the Resolver expires records (WithExpiry), each TTL is the time
+// remaining until the record expires from the cache.
+func (r *Resolver) ResolveZone(ctx context.Context, w io.Writer, qname, qtype string) error {
+	rrs, err := r.ResolveContext(ctx, qname, qtype)
+	if err != nil {
+		return err
+	}
+	return rrs.WriteZone(w)
+}
+
+// ResolveRaw resolves records of type qtype for qname as ResolveContext does,
+// returning them as dns.RR values, so typed fields such as MX preference,
+// SRV priority, weight and port, and SOA serial are available.
+// With WithRawRecords or WithDNSSEC, records are copies of those received
+// from name servers, retained in the cache. Otherwise, or if no original was
+// retained, a record is converted from its RR, and omitted if it can’t be,
+// e.g. SOA records, whose RR holds only the primary name server.
+func (r *Resolver) ResolveRaw(ctx context.Context, qname, qtype string) ([]dns.RR, error) {
+	qname, err := r.normalize(qname)
+	if err != nil {
+		return nil, err
+	}
+	rrs, err := r.resolveTop(ctx, qname, qtype)
+	if err != nil {
+		return nil, err
+	}
+	drrs := make([]dns.RR, 0, len(rrs))
+	seen := make(map[rrKey]bool)
+	for _, rr := range rrs {
+		raw := r.cache.getRaw(rr)
+		if len(raw) == 0 {
+			if drr, ok := rr.dnsRR(); ok {
+				drrs = append(drrs, drr)
+			}
+			continue
+		}
+		// Records differing only in TXT strings share an RR value
+		if k := newRRKey(rr, nil); !seen[k] {
+			seen[k] = true
+			for _, drr := range raw {
+				drr.Header().Ttl = uint32(rr.TTL / time.Second)
+				drrs = append(drrs, drr)
+			}
+		}
+	}
+	return drrs, nil
+}
+
+// resolveTop resolves a normalized qname within the Resolver timeout.
+func (r *Resolver) resolveTop(ctx context.Context, qname, qtype string) (RRs, error) {
+	rrs, _, err := r.resolveDetail(ctx, qname, qtype, &trace{})
+	return rrs, err
+}
+
+// resolveDetail resolves a normalized qname within the Resolver timeout,
+// returning details of the resolution accumulated in t.
+func (r *Resolver) resolveDetail(ctx context.Context, qname, qtype string, t *trace) (RRs, *Detail, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, &Detail{}, timeoutErr(err)
+	}
+	if r.rootErr != nil {
+		return nil, &Detail{}, r.rootErr
+	}
 	ctx, cancel := context.WithTimeout(ctx, r.timeout)
 	defer cancel()
-	return r.resolve(ctx, toLowerFQDN(qname), qtype, 0)
+	ctx = context.WithValue(ctx, traceKey{}, t)
+	rrs, err := r.resolve(ctx, qname, qtype, 0)
+	for i := 0; i < r.retries && temporary(err) && ctx.Err() == nil; i++ {
+		rrs, err = r.resolve(ctx, qname, qtype, 0)
+	}
+	if r.dnssec && err == nil {
+		var secure bool
+		secure, err = r.validate(ctx, qname, qtype, 1)
+		t.authenticated(secure)
+		if err != nil {
+			rrs = nil
+		}
+	}
+	rrs, err = r.results(qname, qtype, rrs, timeoutErr(err))
+	detail := t.result()
+	r.stats.addDepth(detail.Depth)
+	return rrs, detail, err
+}
+
+// results applies result checking and filtering options to rrs and err resolved for qname and qtype.
+func (r *Resolver) results(qname, qtype string, rrs RRs, err error) (RRs, error) {
+	if r.strictCNAME && err == nil && r.hasCNAMEAndOtherData(qname, rrs) {
+		return nil, ErrCNAMEAndOtherData
+	}
+	if r.noDataErr && err == nil && qtype != "" {
+		switch r.reason(qname, qtype, rrs, r.cnameChain(qname, rrs)) {
+		case ReasonNoData, ReasonDanglingCNAME:
+			rrs, err = nil, ErrNoData
+		}
+	}
+	if r.answerOnly {
+		rrs = answers(qname, qtype, rrs)
+	}
+	if r.withoutSOA && qtype != "SOA" {
+		rrs = withoutType(rrs, "SOA")
+	}
+	if r.withoutGlue {
+		rrs = withoutGlue(qname, rrs)
+	}
+	if r.negativeSOA && (err == NXDOMAIN || err == ErrNoData || (err == nil && len(rrs) == 0)) {
+		if soa := r.zoneSOA(qname); soa != nil {
+			rrs = append(rrs, soa...)
+		}
+	}
+	if r.roundRobin && err == nil {
+		rrs = r.rotate(qname, rrs)
+	}
+	return rrs, err
+}
+
+// withoutType returns the records in rrs that are not of type rtype.
+func withoutType(rrs RRs, rtype string) RRs {
+	if rrs == nil {
+		return nil
+	}
+	out := make(RRs, 0, len(rrs))
+	for _, rr := range rrs {
+		if rr.Type != rtype {
+			out = append(out, rr)
+		}
+	}
+	return out
+}
+
+// withoutGlue returns the records in rrs other than A and AAAA records
+// for the name servers in NS records of rrs, keeping those for qname.
+func withoutGlue(qname string, rrs RRs) RRs {
+	if rrs == nil {
+		return nil
+	}
+	hosts := make(map[string]bool)
+	for _, rr := range rrs {
+		if rr.Type == "NS" {
+			hosts[rr.Value] = true
+		}
+	}
+	out := make(RRs, 0, len(rrs))
+	for _, rr := range rrs {
+		if (rr.Type != "A" && rr.Type != "AAAA") || !hosts[rr.Name] || rr.Name == qname {
+			out = append(out, rr)
+		}
+	}
+	return out
+}
+
+// hasCNAMEAndOtherData reports whether qname or any name in rrs
+// has cached records that include a CNAME alongside other data.
+func (r *Resolver) hasCNAMEAndOtherData(qname string, rrs RRs) bool {
+	names := map[string]bool{qname: true}
+	for _, rr := range rrs {
+		names[rr.Name] = true
+	}
+	for name := range names {
+		if any, _ := r.cacheLookup(name, ""); hasCNAMEAndOtherData(any) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasCNAMEAndOtherData reports whether any name in rrs has a CNAME record
+// alongside other records, which RFC 1034 forbids. DNSSEC records are permitted.
+func hasCNAMEAndOtherData(rrs RRs) bool {
+	cnames := make(map[string]bool)
+	for _, rr := range rrs {
+		if rr.Type == "CNAME" {
+			cnames[rr.Name] = true
+		}
+	}
+	if len(cnames) == 0 {
+		return false
+	}
+	for _, rr := range rrs {
+		switch rr.Type {
+		case "CNAME", "RRSIG", "NSEC":
+			continue
+		}
+		if cnames[rr.Name] {
+			return true
+		}
+	}
+	return false
+}
+
+// returned returns rrs as returned to callers: with the names of records for
+// qname replaced with query, the form of qname passed by the caller, if the
+// Resolver returns names as queried, and with TXT values truncated to maxTXT.
+func (r *Resolver) returned(query, qname string, rrs RRs) RRs {
+	rename := r.returnAsQueried && query != qname
+	if (!rename && r.maxTXT <= 0) || len(rrs) == 0 {
+		return rrs
+	}
+	out := make(RRs, len(rrs))
+	for i, rr := range rrs {
+		if rename && rr.Name == qname {
+			rr.Name = query
+		}
+		if r.maxTXT > 0 && rr.Type == "TXT" && len(rr.Value) > r.maxTXT {
+			rr.Value = rr.Value[:r.maxTXT] + "..."
+		}
+		out[i] = rr
+	}
+	return out
+}
+
+// zoneSOA returns the cached SOA records of the closest zone enclosing qname.
+func (r *Resolver) zoneSOA(qname string) RRs {
+	for pname, ok := qname, true; ok; pname, ok = parent(pname) {
+		if rrs, _ := r.cacheLookup(pname, "SOA"); len(rrs) > 0 {
+			return rrs
+		}
+	}
+	return nil
+}
+
+// answers returns the records in rrs that answer qname and qtype,
+// following any CNAME records for qname.
+func answers(qname, qtype string, rrs RRs) RRs {
+	if rrs == nil {
+		return nil
+	}
+	names := map[string]bool{qname: true}
+	for more := true; more; {
+		more = false
+		for _, rr := range rrs {
+			if rr.Type == "CNAME" && names[rr.Name] && !names[rr.Value] {
+				names[rr.Value] = true
+				more = true
+			}
+		}
+	}
+	out := make(RRs, 0, len(rrs))
+	for _, rr := range rrs {
+		if names[rr.Name] && (qtype == "" || rr.Type == qtype || rr.Type == "CNAME") {
+			out = append(out, rr)
+		}
+	}
+	return out
+}
+
+// PinZone resolves the name servers for zone and their addresses of the
+// address families the Resolver queries, and protects them from cache
+// eviction and expiry until UnpinZone is called.
+// Subsequent resolutions of names under zone reuse the pinned delegation.
+func (r *Resolver) PinZone(ctx context.Context, zone string) error {
+	zone, err := r.normalize(zone)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	nrrs, err := r.resolve(ctx, zone, "NS", 0)
+	if err != nil {
+		return timeoutErr(err)
+	}
+	names := []string{zone}
+	for _, nrr := range nrrs {
+		if nrr.Type != "NS" || nrr.Name != zone {
+			continue
+		}
+		if _, err = r.nameserverIPs(ctx, nrr.Value, 0); err != nil {
+			continue
+		}
+		names = append(names, nrr.Value)
+	}
+	if len(names) == 1 {
+		if err == nil {
+			err = ErrNoResponse
+		}
+		return timeoutErr(err)
+	}
+	r.cache.pin(zone, names)
+	return nil
+}
+
+// Prime adds rrs to the cache as local data, as if received in authoritative
+// answers, and pins them: like delegations pinned with PinZone, primed records
+// are never evicted or expired. Priming a name again adds to its records.
+func (r *Resolver) Prime(rrs RRs) {
+	for _, rr := range rrs {
+		rr.Name = toLowerFQDN(rr.Name)
+		rr.Authoritative = true
+		r.cache.pin(primeKey+rr.Name, []string{rr.Name})
+		r.cache.add(rr.Name, rr)
+	}
+}
+
+// primeKey prefixes the cache pin keys of primed names,
+// distinguishing them from zones pinned with PinZone.
+const primeKey = "prime "
+
+// UnpinZone releases a delegation pinned with PinZone,
+// making it subject to normal cache eviction and expiry.
+func (r *Resolver) UnpinZone(zone string) {
+	if zone, err := r.normalize(zone); err == nil {
+		r.cache.unpin(zone)
+	}
+}
+
+// Warmup pre-resolves the name servers for tlds, or WarmupTLDs if none are
+// specified, so the cache is primed before the Resolver is used.
+// The TLDs are resolved concurrently, subject to ctx and the Resolver timeout.
+// Any errors are joined and returned.
+func (r *Resolver) Warmup(ctx context.Context, tlds ...string) error {
+	if len(tlds) == 0 {
+		tlds = WarmupTLDs
+	}
+	errs := make([]error, len(tlds))
+	var wg sync.WaitGroup
+	for i, tld := range tlds {
+		wg.Add(1)
+		go func(i int, qname string) {
+			defer wg.Done()
+			if _, err := r.resolveTop(ctx, qname, "NS"); err != nil {
+				errs[i] = fmt.Errorf("%s: %w", qname, err)
+			}
+		}(i, toLowerFQDN(tld))
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Range calls fn for each name in the cache and its cached records,
+// in random order, until fn returns false. Names cached as NXDOMAIN are
+// passed with empty RRs. Records are passed regardless of expiry.
+// The cache is locked while Range runs, so fn must not call methods on r.
+func (r *Resolver) Range(fn func(qname string, rrs RRs) bool) {
+	r.cache.rangeEntries(fn)
+}
+
+// SetCacheCapacity changes the capacity of the Resolver cache to n entries,
+// evicting entries immediately if the cache holds more than n.
+// Pinned entries are not evicted. If n <= 0, MinCacheCapacity is used.
+// It is safe to call while resolutions are in progress.
+func (r *Resolver) SetCacheCapacity(n int) {
+	r.cache.setCapacity(n)
+}
+
+// ResolveAll queries qname for each of AllTypes and returns the merged,
+// de-duplicated results. Up to BatchConcurrency types are resolved at once,
+// each subject to ctx and the Resolver timeout.
+// Records found are returned along with any errors for individual types,
+// unless qname does not exist, in which case ResolveAll returns NXDOMAIN.
+func (r *Resolver) ResolveAll(ctx context.Context, qname string) (RRs, error) {
+	query := qname
+	qname, err := r.normalize(qname)
+	if err != nil {
+		return nil, err
+	}
+	sem := batchSem()
+	results := make([]RRs, len(AllTypes))
+	errs := make([]error, len(AllTypes))
+	var wg sync.WaitGroup
+	for i, qtype := range AllTypes {
+		wg.Add(1)
+		go func(i int, qtype string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i], errs[i] = r.resolveTop(ctx, qname, qtype)
+		}(i, qtype)
+	}
+	wg.Wait()
+
+	var rrs RRs
+	seen := make(map[rrKey]bool)
+	for i, err := range errs {
+		if err == NXDOMAIN {
+			return nil, err
+		}
+		if err != nil {
+			errs[i] = fmt.Errorf("%s: %w", AllTypes[i], err)
+		}
+		for _, rr := range results[i] {
+			k := newRRKey(rr, nil)
+			if !seen[k] {
+				seen[k] = true
+				rrs = append(rrs, rr)
+			}
+		}
+	}
+	return r.returned(query, qname, rrs), errors.Join(errs...)
+}
+
+// batchSem returns a semaphore limiting concurrent resolutions
+// in batch operations to BatchConcurrency.
+func batchSem() chan struct{} {
+	n := BatchConcurrency
+	if n <= 0 {
+		n = 1
+	}
+	return make(chan struct{}, n)
+}
+
+// normalize validates qname and returns it lowercase and fully qualified,
+// rewritten by the name rewrite function, if any.
+// It returns an error if qname is invalid or not allowed.
+func (r *Resolver) normalize(qname string) (string, error) {
+	if !validName(qname) {
+		return "", ErrInvalidName
+	}
+	qname = toLowerFQDN(qname)
+	if r.rewrite != nil {
+		qname = r.rewrite(qname)
+		if !validName(qname) {
+			return "", ErrInvalidName
+		}
+		qname = toLowerFQDN(qname)
+	}
+	if !r.allowed(qname) {
+		return "", ErrNotAllowed
+	}
+	return qname, nil
+}
+
+// allowed reports whether qname may be resolved under r’s allowlist, if any.
+func (r *Resolver) allowed(qname string) bool {
+	if r.allowlist == nil {
+		return true
+	}
+	for _, name := range r.allowlist {
+		if dns.IsSubDomain(name, qname) {
+			return true
+		}
+	}
+	return false
+}
+
+// maxRecursionError returns a MaxRecursionError for qname and qtype
+// with the chain of resolutions in ctx.
+func maxRecursionError(ctx context.Context, qname, qtype string) error {
+	var chain []string
+	for f := callerFrame(ctx); f != nil; f = f.caller {
+		chain = append(chain, f.qname+" "+f.qtype)
+	}
+	slices.Reverse(chain)
+	return &MaxRecursionError{Qname: qname, Qtype: qtype, Chain: chain}
+}
+
+// frame is a qname and qtype being resolved,
+// linked to the resolution that required it.
+type frame struct {
+	qname  string
+	qtype  string
+	caller *frame
+}
+
+type frameKey struct{}
+
+// callerFrame returns the innermost frame being resolved in ctx, or nil.
+func callerFrame(ctx context.Context) *frame {
+	f, _ := ctx.Value(frameKey{}).(*frame)
+	return f
 }
 
 func (r *Resolver) resolve(ctx context.Context, qname, qtype string, depth int) (RRs, error) {
-	if depth++; depth > MaxRecursion {
+	depth++
+	traceFrom(ctx).depth(depth)
+	if depth > MaxRecursion {
 		logMaxRecursion(qname, qtype, depth)
-		return nil, ErrMaxRecursion
+		err := maxRecursionError(ctx, qname, qtype)
+		r.events.resolved(qname, qtype, depth, err)
+		return nil, err
 	}
 	rrs, err := r.cacheGet(ctx, qname, qtype)
 	if err != nil {
+		if err == NXDOMAIN {
+			r.metrics.IncCacheHit()
+			if depth == 1 {
+				traceFrom(ctx).fromCache()
+			}
+		}
+		r.events.resolved(qname, qtype, depth, err)
 		return nil, err
 	}
-	if len(rrs) > 0 {
+	if rrs != nil {
+		r.metrics.IncCacheHit()
+		r.events.send(Event{Type: EventCacheHit, Name: qname, Qtype: qtype, Depth: depth})
+		if depth == 1 {
+			traceFrom(ctx).fromCache()
+		}
 		return rrs, nil
 	}
+	r.metrics.IncCacheMiss()
+	caller := callerFrame(ctx)
+	for f := caller; f != nil; f = f.caller {
+		if f.qname == qname && f.qtype == qtype {
+			logDelegationLoop(qname, qtype, depth)
+			r.events.resolved(qname, qtype, depth, ErrDelegationLoop)
+			return nil, ErrDelegationLoop
+		}
+	}
+	ctx = context.WithValue(ctx, frameKey{}, &frame{qname, qtype, caller})
 	logResolveStart(qname, qtype, depth)
+	r.events.send(Event{Type: EventResolveStart, Name: qname, Qtype: qtype, Depth: depth})
 	start := time.Now()
-	rrs, err = r.iterateParents(ctx, qname, qtype, depth)
+	if depth == 1 && r.flights != nil {
+		rrs, err = r.shared(ctx, qname, qtype, depth)
+	} else {
+		rrs, err = r.lookup(ctx, qname, qtype, depth)
+	}
 	logResolveEnd(qname, qtype, rrs, depth, start, err)
+	r.events.resolved(qname, qtype, depth, err)
 	return rrs, err
 }
 
+// shared is like lookup, but shares a single lookup among concurrent
+// callers for the same qname and qtype. The shared lookup is not canceled
+// if a caller’s ctx is done. Only top-level resolutions are shared,
+// since a recursive resolution waiting on another could deadlock.
+// The shared lookup is traced separately, and its trace merged into
+// the trace of each caller.
+func (r *Resolver) shared(ctx context.Context, qname, qtype string, depth int) (RRs, error) {
+	ch := r.flights.DoChan(qname+" "+qtype, func() (any, error) {
+		ft := &trace{servers: make(map[string]struct{})}
+		ctx := context.WithValue(context.WithoutCancel(ctx), traceKey{}, ft)
+		ctx, cancel := context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+		rrs, err := r.lookup(ctx, qname, qtype, depth)
+		return flight{rrs, ft}, err
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		f, _ := res.Val.(flight)
+		traceFrom(ctx).merge(f.t)
+		return slices.Clone(f.rrs), res.Err
+	}
+}
+
+// flight is the result of a shared lookup, with its trace.
+type flight struct {
+	rrs RRs
+	t   *trace
+}
+
+// lookup resolves qname and qtype from the network, via the forwarder if set.
+func (r *Resolver) lookup(ctx context.Context, qname, qtype string, depth int) (RRs, error) {
+	if r.forwarder != "" {
+		return r.forward(ctx, qname, qtype, depth)
+	}
+	return r.iterateParents(ctx, qname, qtype, depth)
+}
+
+// forward resolves qname and qtype with a recursive query to the forwarder.
+func (r *Resolver) forward(ctx context.Context, qname, qtype string, depth int) (RRs, error) {
+	if net.ParseIP(r.forwarder) == nil {
+		return nil, ErrInvalidServer
+	}
+	rrs, err := r.exchangeIP(ctx, "", r.forwarder, r.forwarder, qname, qtype, depth)
+	if err != nil {
+		return nil, err
+	}
+	if depth == 1 {
+		traceFrom(ctx).answeredBy(r.forwarder)
+	}
+	if r.minimal {
+		return rrs, nil
+	}
+	// Records for CNAME targets in the response were cached
+	return r.resolveCNAMEs(ctx, qname, qtype, rrs, depth)
+}
+
+// answer is a successful response from the name server at address ip.
+type answer struct {
+	rrs RRs
+	ip  string
+}
+
 func (r *Resolver) iterateParents(ctx context.Context, qname, qtype string, depth int) (RRs, error) {
-	chanRRs := make(chan RRs, MaxNameservers)
+	chanAnswers := make(chan answer, MaxNameservers)
 	chanErrs := make(chan error, MaxNameservers)
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
+	var lastErr error // last error from a parent zone, reported if no parent answers
 	for pname, ok := qname, true; ok; pname, ok = parent(pname) {
-		// If we’re looking for [foo.com,NS], then move on to the parent ([com,NS])
-		if pname == qname && qtype == "NS" {
+		// If we’re looking for [foo.com,NS], then move on to the parent ([com,NS]).
+		// DS records are likewise served by the parent zone.
+		if pname == qname && (qtype == "NS" || qtype == "DS") {
 			continue
 		}
 
-		// Only query TLDs against the root nameservers
-		if pname == "." && dns.CountLabel(qname) != 1 {
+		// Only query the root and TLDs against the root nameservers
+		if pname == "." && dns.CountLabel(qname) > 1 {
 			// fmt.Fprintf(os.Stderr, "Warning: non-TLD query at root: dig +norecurse %s %s\n", qname, qtype)
-			return nil, nil
+			return nil, lastErr
 		}
+		traceFrom(ctx).iteration()
 
 		// Get nameservers
-		nrrs, err := r.resolve(ctx, pname, "NS", depth)
-		if err == NXDOMAIN || err == ErrTimeout || err == context.DeadlineExceeded {
+		var err error
+		nrrs := r.staleLookup(pname, "NS")
+		if nrrs == nil {
+			nrrs, err = r.resolve(ctx, pname, "NS", depth)
+		}
+		if err == NXDOMAIN || err == ErrTimeout || err == context.DeadlineExceeded || err == ErrDelegationLoop || errors.Is(err, ErrMaxRecursion) {
 			return nil, err
 		}
 		if err != nil {
+			lastErr = err
 			continue
 		}
 
@@ -218,7 +1571,7 @@ func (r *Resolver) iterateParents(ctx context.Context, qname, qtype string, dept
 			if err != nil {
 				return nil, err
 			}
-			if len(rrs) > 0 {
+			if rrs != nil {
 				return rrs, nil
 			}
 		}
@@ -232,11 +1585,11 @@ func (r *Resolver) iterateParents(ctx context.Context, qname, qtype string, dept
 			}
 
 			go func(host string) {
-				rrs, err := r.exchange(ctx, host, qname, qtype, depth)
+				rrs, ip, err := r.exchange(ctx, pname, host, qname, qtype, depth)
 				if err != nil {
 					chanErrs <- err
 				} else {
-					chanRRs <- rrs
+					chanAnswers <- answer{rrs, ip}
 				}
 			}(nrr.Value)
 
@@ -244,11 +1597,19 @@ func (r *Resolver) iterateParents(ctx context.Context, qname, qtype string, dept
 		}
 
 		// Wait for answer, error, or cancellation
+		var fatal error
 		for ; count > 0; count-- {
 			select {
 			case <-ctx.Done():
 				return nil, ctx.Err()
-			case rrs := <-chanRRs:
+			case a := <-chanAnswers:
+				rrs := a.rrs
+				if depth == 1 {
+					traceFrom(ctx).answeredBy(a.ip)
+				}
+				if r.minimal {
+					return rrs, nil
+				}
 				for _, nrr := range nrrs {
 					if nrr.Name == qname {
 						rrs = append(rrs, nrr)
@@ -258,13 +1619,22 @@ func (r *Resolver) iterateParents(ctx context.Context, qname, qtype string, dept
 				cancel() // stop any other work here before recursing
 				return r.resolveCNAMEs(ctx, qname, qtype, rrs, depth)
 			case err = <-chanErrs:
+				lastErr = err
 				if err == NXDOMAIN {
 					return nil, err
 				}
+				if err == ErrDelegationLoop || errors.Is(err, ErrMaxRecursion) {
+					fatal = err
+				}
 			}
 
 		}
 
+		// Name servers that depend on this resolution, or are too deep to resolve, can’t answer it
+		if fatal != nil {
+			return nil, fatal
+		}
+
 		// NS queries naturally recurse, so stop further iteration
 		if qtype == "NS" {
 			return nil, err
@@ -274,46 +1644,169 @@ func (r *Resolver) iterateParents(ctx context.Context, qname, qtype string, dept
 	return nil, ErrNoResponse
 }
 
-func (r *Resolver) exchange(ctx context.Context, host, qname, qtype string, depth int) (RRs, error) {
-	count := 0
-	arrs, err := r.resolve(ctx, host, "A", depth)
+// exchange queries the addresses of name server host in turn,
+// returning the records from the first to respond and its address.
+func (r *Resolver) exchange(ctx context.Context, zone, host, qname, qtype string, depth int) (RRs, string, error) {
+	ips, err := r.nameserverIPs(ctx, host, depth)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	for _, arr := range arrs {
-		// FIXME: support AAAA records?
-		if arr.Type != "A" {
-			continue
+	if r.happyEyeballs {
+		var v4, v6 []string
+		for _, ip := range ips[:min(len(ips), MaxIPs)] {
+			if strings.Contains(ip, ":") {
+				v6 = append(v6, ip)
+			} else {
+				v4 = append(v4, ip)
+			}
+		}
+		if len(v4) > 0 && len(v6) > 0 {
+			rrs, ip, err := r.exchangeHappyEyeballs(ctx, zone, host, v6, v4, qname, qtype, depth)
+			if err == ErrNoResponse && len(ips) > MaxIPs {
+				err = ErrMaxIPs
+			}
+			return rrs, ip, err
 		}
+	}
+	return r.exchangeIPs(ctx, zone, host, ips, qname, qtype, depth)
+}
 
-		// Never query more than MaxIPs for any nameserver
-		if count++; count > MaxIPs {
-			return nil, ErrMaxIPs
+// exchangeIPs queries name server host at each of ips in turn,
+// returning the records from the first to respond and its address.
+func (r *Resolver) exchangeIPs(ctx context.Context, zone, host string, ips []string, qname, qtype string, depth int) (RRs, string, error) {
+	for i, ip := range ips {
+		// Never query more than MaxIPs for any nameserver, across address families
+		if i >= MaxIPs {
+			return nil, "", ErrMaxIPs
 		}
 
-		rrs, err := r.exchangeIP(ctx, host, arr.Value, qname, qtype, depth)
+		rrs, err := r.exchangeIP(ctx, zone, host, ip, qname, qtype, depth)
 		if err == nil || err == NXDOMAIN || err == ErrTimeout {
-			return rrs, err
+			return rrs, ip, err
 		}
 
 		if ctx.Err() != nil {
-			return nil, ctx.Err()
+			return nil, "", ctx.Err()
+		}
+	}
+
+	return nil, "", ErrNoResponse
+}
+
+// exchangeHappyEyeballs queries name server host at its IPv6 addresses,
+// then also at its IPv4 addresses once HappyEyeballsDelay has passed
+// or the IPv6 addresses have failed, returning the first answer.
+func (r *Resolver) exchangeHappyEyeballs(ctx context.Context, zone, host string, v6, v4 []string, qname, qtype string, depth int) (RRs, string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		rrs RRs
+		ip  string
+		err error
+	}
+	results := make(chan result, 2)
+	attempt := func(ips []string) {
+		rrs, ip, err := r.exchangeIPs(ctx, zone, host, ips, qname, qtype, depth)
+		results <- result{rrs, ip, err}
+	}
+	go attempt(v6)
+	timer := time.NewTimer(HappyEyeballsDelay)
+	defer timer.Stop()
+	delay := timer.C
+	pending := 1
+	var err error
+	for pending > 0 {
+		select {
+		case <-delay:
+			delay = nil
+			pending++
+			go attempt(v4)
+		case res := <-results:
+			pending--
+			if res.err == nil || res.err == NXDOMAIN || res.err == ErrTimeout {
+				return res.rrs, res.ip, res.err
+			}
+			err = res.err
+			if delay != nil { // IPv6 failed before the delay
+				delay = nil
+				pending++
+				go attempt(v4)
+			}
+		}
+	}
+	return nil, "", err
+}
+
+// nameserverIPs returns the IP addresses to query for name server host,
+// of the address families the Resolver queries. In DualStack mode, IPv4 and
+// IPv6 addresses are interleaved so both families are attempted before
+// the addresses of either are exhausted.
+func (r *Resolver) nameserverIPs(ctx context.Context, host string, depth int) ([]string, error) {
+	switch r.family {
+	case IPv4Only:
+		return r.hostIPs(ctx, host, "A", depth)
+	case IPv6Only:
+		return r.hostIPs(ctx, host, "AAAA", depth)
+	}
+	v4, err := r.hostIPs(ctx, host, "A", depth)
+	v6, err6 := r.hostIPs(ctx, host, "AAAA", depth)
+	if len(v4) == 0 && len(v6) == 0 {
+		if err == nil {
+			err = err6
+		}
+		return nil, err
+	}
+	ips := make([]string, 0, len(v4)+len(v6))
+	for i := 0; i < len(v4) || i < len(v6); i++ {
+		if i < len(v4) {
+			ips = append(ips, v4[i])
+		}
+		if i < len(v6) {
+			ips = append(ips, v6[i])
 		}
 	}
+	return ips, nil
+}
 
-	return nil, ErrNoARecords
+// hostIPs resolves the addresses of type qtype (A or AAAA) for name server host.
+func (r *Resolver) hostIPs(ctx context.Context, host, qtype string, depth int) ([]string, error) {
+	var err error
+	rrs := r.staleLookup(host, qtype)
+	if rrs == nil {
+		rrs, err = r.resolve(ctx, host, qtype, depth)
+	}
+	if err == NXDOMAIN {
+		// A nonexistent name server doesn’t mean qname doesn’t exist
+		return nil, ErrNoARecords
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ips []string
+	for _, rr := range rrs {
+		if rr.Type == qtype {
+			ips = append(ips, rr.Value)
+		}
+	}
+	return ips, nil
 }
 
 var dialerDefault = &net.Dialer{}
 
-func (r *Resolver) exchangeIP(ctx context.Context, host, ip, qname, qtype string, depth int) (RRs, error) {
+func (r *Resolver) exchangeIP(ctx context.Context, zone, host, ip, qname, qtype string, depth int) (RRs, error) {
 	dtype := dns.StringToType[qtype]
 	if dtype == 0 {
 		dtype = dns.TypeA
 	}
 	var qmsg dns.Msg
 	qmsg.SetQuestion(qname, dtype)
-	qmsg.MsgHdr.RecursionDesired = false
+	qmsg.MsgHdr.RecursionDesired = r.forwarder != ""
+	if r.dnssecOK {
+		qmsg.SetEdns0(dns.DefaultMsgSize, true)
+	}
+	if r.queryModifier != nil {
+		r.queryModifier(&qmsg)
+	}
 
 	// Synchronously query this DNS server
 	start := time.Now()
@@ -328,35 +1821,19 @@ func (r *Resolver) exchangeIP(ctx context.Context, host, ip, qname, qtype string
 	// client must finish within remaining timeout
 	client := &dns.Client{Timeout: timeout}
 
-	dialer := r.dialer
-	if dialer == nil {
-		dialer = dialerDefault
-	}
-
-	addr := net.JoinHostPort(ip, "53")
-	conn, err := dialer.DialContext(ctx, "udp", addr)
-	var rmsg *dns.Msg
-	var dur time.Duration
-	if err == nil {
-		dconn := &dns.Conn{Conn: conn}
-		rmsg, dur, err = client.ExchangeWithConnContext(ctx, &qmsg, dconn)
-		conn.Close()
+	traceFrom(ctx).server(ip)
+	rmsg, dur, err := r.exchangeMsg(ctx, client, zone, ip, &qmsg, start)
+	r.nsStats.record(ip, err)
+	switch {
+	case err == nil:
+		r.metrics.IncExchange(ip, rmsg.Rcode)
+		r.metrics.ObserveExchangeLatency(dur)
+	case isTimeout(err):
+		r.metrics.IncTimeout(ip)
 	}
-	if r.tcpRetry && rmsg != nil && rmsg.MsgHdr.Truncated {
-		// Since we are doing another query, we need to recheck the deadline
-		if dl, ok := ctx.Deadline(); ok {
-			if start.After(dl.Add(-TypicalResponseTime)) { // bail if we can't finish in time (start is too close to deadline)
-				return nil, ErrTimeout
-			}
-			client.Timeout = dl.Sub(start)
-		}
-		// Retry with TCP
-		conn, err := dialer.DialContext(ctx, "tcp", addr)
-		if err == nil {
-			dconn := &dns.Conn{Conn: conn}
-			rmsg, dur, err = client.ExchangeWithConnContext(ctx, &qmsg, dconn)
-			conn.Close()
-		}
+	r.events.send(Event{Type: EventExchange, Name: qname, Qtype: qtype, Depth: depth, Host: host, IP: ip, Duration: dur, Err: err})
+	if err == ErrTimeout {
+		return nil, err
 	}
 
 	select {
@@ -370,34 +1847,47 @@ func (r *Resolver) exchangeIP(ctx context.Context, host, ip, qname, qtype string
 		return nil, err
 	}
 
-	// FIXME: cache NXDOMAIN responses responsibly
+	// We asked for no recursion, so a recursive, non-authoritative answer
+	// suggests a forwarder masquerading as an authoritative name server
+	if r.rejectRecursed && r.forwarder == "" && rmsg.RecursionAvailable && !rmsg.Authoritative &&
+		(len(rmsg.Answer) > 0 || rmsg.Rcode == dns.RcodeNameError) {
+		return nil, ErrRecursedAnswer
+	}
+
 	if rmsg.Rcode == dns.RcodeNameError {
-		var hasSOA bool
-		if qtype == "NS" {
-			for _, drr := range rmsg.Ns {
-				rr, ok := convertRR(drr, r.expire)
-				if !ok {
-					continue
-				}
-				if rr.Type == "SOA" {
-					hasSOA = true
-					break
-				}
-			}
-		}
-		if !hasSOA {
-			r.cache.addNX(qname)
+		if qtype != "NS" || !hasSOA(rmsg.Ns) {
+			r.saveDNSRR(host, qname, rmsg.Ns, rmsg.Authoritative)
+			r.cache.addNX(qname, r.negativeExpiry(rmsg.Ns))
 			return nil, NXDOMAIN
 		}
 	} else if rmsg.Rcode != dns.RcodeSuccess {
-		return nil, errors.New(dns.RcodeToString[rmsg.Rcode]) // FIXME: should (*Resolver).exchange special-case this error?
+		return nil, rcodeError(rmsg.Rcode) // FIXME: should (*Resolver).exchange special-case this error?
+	} else if r.cacheNoData && qtype != "" && len(rmsg.Answer) == 0 && hasSOA(rmsg.Ns) {
+		r.cache.addNoData(qname, qtype, r.negativeExpiry(rmsg.Ns))
+	}
+	if r.dnssec && qtype == "DS" && len(rmsg.Answer) == 0 {
+		// Keep the proof that qname has no DS records for validation
+		for _, drr := range rmsg.Ns {
+			switch drr.(type) {
+			case *dns.NSEC, *dns.NSEC3, *dns.RRSIG:
+				if rr, ok := convertRR(drr, r.expire); ok {
+					r.cache.addRR(qname, rr, drr, true)
+				}
+			}
+		}
+	}
+
+	if depth == 1 {
+		traceFrom(ctx).extra(ip, rmsg.Extra)
 	}
 
 	// Cache records returned
-	rrs := r.saveDNSRR(host, qname, append(append(rmsg.Answer, rmsg.Ns...), rmsg.Extra...))
+	// Additional section records are glue, never authoritative
+	rrs := r.saveDNSRR(host, qname, append(rmsg.Answer, rmsg.Ns...), rmsg.Authoritative)
+	rrs = append(rrs, r.saveDNSRR(host, qname, rmsg.Extra, false)...)
 
 	// Resolve IP addresses of TLD name servers if NS query doesn’t return additional section
-	if qtype == "NS" {
+	if qtype == "NS" && !r.minimal {
 		for _, rr := range rrs {
 			if rr.Type != "NS" {
 				continue
@@ -410,7 +1900,7 @@ func (r *Resolver) exchangeIP(ctx context.Context, host, ip, qname, qtype string
 				break
 			}
 			if len(arrs) == 0 {
-				arrs, err = r.exchangeIP(ctx, host, ip, rr.Value, "A", depth+1)
+				arrs, err = r.exchangeIP(ctx, zone, host, ip, rr.Value, "A", depth+1)
 				if err != nil {
 					break
 				}
@@ -422,16 +1912,262 @@ func (r *Resolver) exchangeIP(ctx context.Context, host, ip, qname, qtype string
 	return rrs, nil
 }
 
+// exchangeMsg sends qmsg to ip, an address of a name server for zone,
+// retrying with TCP if enabled and the response is truncated.
+// Concurrent queries to root and TLD name servers are limited by the root concurrency.
+func (r *Resolver) exchangeMsg(ctx context.Context, client *dns.Client, zone, ip string, qmsg *dns.Msg, start time.Time) (*dns.Msg, time.Duration, error) {
+	if dns.CountLabel(zone) <= 1 && r.forwarder == "" {
+		select {
+		case r.rootSem <- struct{}{}:
+			defer func() { <-r.rootSem }()
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+	}
+	if err := r.limiter.acquire(ctx); err != nil {
+		return nil, 0, err
+	}
+	defer r.limiter.release()
+
+	if r.transport != nil {
+		rmsg, err := r.transport.Exchange(ctx, ip, qmsg)
+		if err == nil && r.maxResponse > 0 && wireLen(rmsg) > r.maxResponse {
+			err = ErrResponseTooLarge
+		}
+		return rmsg, time.Since(start), err
+	}
+
+	dialer := r.dialer
+	if dialer == nil {
+		dialer = dialerDefault
+	}
+
+	network, port := "udp", "53"
+	if r.tcpOnly {
+		network = "tcp"
+	}
+	if r.tls != nil {
+		network, port = "tcp", "853"
+	}
+	if p, ok := r.serverPorts[ip]; ok {
+		port = strconv.Itoa(p)
+	} else if r.port > 0 {
+		port = strconv.Itoa(r.port)
+	}
+	addr := net.JoinHostPort(ip, port)
+	conn, err := r.dial(ctx, dialer, network, addr)
+	if err == nil && r.tls != nil {
+		conn, err = r.handshake(ctx, conn, ip)
+	}
+	if err != nil && network == "udp" && ctx.Err() == nil && !isContextErr(err) {
+		// Some dialers, such as SOCKS5 proxies, only support TCP
+		network = "tcp"
+		conn, err = r.dial(ctx, dialer, network, addr)
+	}
+	var rmsg *dns.Msg
+	var dur time.Duration
+	if err == nil {
+		// Read oversized UDP responses from servers that ignore the 512-byte limit
+		client.UDPSize = dns.MaxMsgSize
+		if r.maxResponse > 0 {
+			client.UDPSize = uint16(min(r.maxResponse, dns.MaxMsgSize))
+		}
+		conn = r.limitConn(conn)
+		dconn := &dns.Conn{Conn: conn}
+		query := qmsg
+		if network == "tcp" {
+			query = r.tcpQuery(qmsg)
+		}
+		rmsg, dur, err = client.ExchangeWithConnContext(ctx, query, dconn)
+		conn.Close()
+	}
+	if network == "udp" && rmsg != nil && ((r.tcpRetry && rmsg.MsgHdr.Truncated) || slices.Contains(r.tcpRcodes, rmsg.Rcode)) {
+		// Since we are doing another query, we need to recheck the deadline
+		if dl, ok := ctx.Deadline(); ok {
+			if start.After(dl.Add(-TypicalResponseTime)) { // bail if we can't finish in time (start is too close to deadline)
+				return nil, 0, ErrTimeout
+			}
+			client.Timeout = dl.Sub(start)
+		}
+		// Retry with TCP
+		r.metrics.IncTCPRetry()
+		conn, err = r.dial(ctx, dialer, "tcp", addr)
+		if err == nil {
+			conn = r.limitConn(conn)
+			dconn := &dns.Conn{Conn: conn}
+			rmsg, dur, err = client.ExchangeWithConnContext(ctx, r.tcpQuery(qmsg), dconn)
+			conn.Close()
+		}
+	}
+	return rmsg, dur, err
+}
+
+// wireLen returns the length of m packed with name compression.
+// Unpacked messages don’t record whether they were compressed,
+// so m.Len alone may overstate the size received.
+func wireLen(m *dns.Msg) int {
+	compress := m.Compress
+	m.Compress = true
+	n := m.Len()
+	m.Compress = compress
+	return n
+}
+
+// limitConn wraps conn to reject responses larger than the maximum response
+// size with ErrResponseTooLarge, before they are read into memory.
+func (r *Resolver) limitConn(conn net.Conn) net.Conn {
+	if r.maxResponse <= 0 {
+		return conn
+	}
+	if _, ok := conn.(net.PacketConn); ok {
+		return &limitPacketConn{conn, r.maxResponse}
+	}
+	return &limitStreamConn{Conn: conn, limit: r.maxResponse}
+}
+
+// limitPacketConn is a datagram net.Conn that rejects datagrams larger than limit.
+type limitPacketConn struct {
+	net.Conn
+	limit int
+}
+
+func (c *limitPacketConn) Read(p []byte) (int, error) {
+	if len(p) > c.limit+1 {
+		p = p[:c.limit+1]
+	}
+	n, err := c.Conn.Read(p)
+	if n > c.limit {
+		return 0, ErrResponseTooLarge
+	}
+	return n, err
+}
+
+func (c *limitPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, err := c.Read(p)
+	return n, c.RemoteAddr(), err
+}
+
+func (c *limitPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	return c.Write(p)
+}
+
+// limitStreamConn is a stream net.Conn carrying a DNS message prefixed by its
+// length, as over TCP, that rejects messages longer than limit.
+type limitStreamConn struct {
+	net.Conn
+	limit  int
+	prefix []byte // unread bytes of the length prefix
+	read   bool   // whether the length prefix has been read
+}
+
+func (c *limitStreamConn) Read(p []byte) (int, error) {
+	if !c.read {
+		var b [2]byte
+		if _, err := io.ReadFull(c.Conn, b[:]); err != nil {
+			return 0, err
+		}
+		c.read = true
+		if int(b[0])<<8|int(b[1]) > c.limit {
+			return 0, ErrResponseTooLarge
+		}
+		c.prefix = b[:]
+	}
+	if len(c.prefix) > 0 {
+		n := copy(p, c.prefix)
+		c.prefix = c.prefix[n:]
+		return n, nil
+	}
+	return c.Conn.Read(p)
+}
+
+// tcpQuery returns qmsg with the EDNS(0) options for queries sent over TCP,
+// if any. qmsg is copied rather than modified.
+func (r *Resolver) tcpQuery(qmsg *dns.Msg) *dns.Msg {
+	if !r.ednsPadding && !r.ednsKeepalive {
+		return qmsg
+	}
+	qmsg = qmsg.Copy()
+	opt := qmsg.IsEdns0()
+	if opt == nil {
+		qmsg.SetEdns0(dns.DefaultMsgSize, false)
+		opt = qmsg.IsEdns0()
+	}
+	if r.ednsKeepalive {
+		opt.Option = append(opt.Option, &dns.EDNS0_TCP_KEEPALIVE{Code: dns.EDNS0TCPKEEPALIVE})
+	}
+	if r.ednsPadding {
+		padding := &dns.EDNS0_PADDING{}
+		opt.Option = append(opt.Option, padding)
+		padding.Padding = make([]byte, (128-qmsg.Len()%128)%128)
+	}
+	return qmsg
+}
+
+// handshake establishes a TLS client connection over conn to the name server at ip.
+// conn is closed if the handshake fails.
+func (r *Resolver) handshake(ctx context.Context, conn net.Conn, ip string) (net.Conn, error) {
+	config := r.tls
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName = ip
+	}
+	tconn := tls.Client(conn, config)
+	if err := tconn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tconn, nil
+}
+
+// dial connects to addr with dialer, within the dial timeout, if any.
+func (r *Resolver) dial(ctx context.Context, dialer ContextDialer, network, addr string) (net.Conn, error) {
+	if r.dialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.dialTimeout)
+		defer cancel()
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
+
+// svcbAlias returns the target name of rr if it is an alias mode (priority 0)
+// SVCB or HTTPS record of type qtype. Alias records with the target "."
+// indicate the service is unavailable, and are not followed.
+func svcbAlias(rr RR, qtype string) (string, bool) {
+	if rr.Type != qtype || (qtype != "SVCB" && qtype != "HTTPS") {
+		return "", false
+	}
+	priority, target, _ := strings.Cut(rr.Value, "\t")
+	target, _, _ = strings.Cut(target, "\t")
+	if priority != "0" || target == "" || target == "." {
+		return "", false
+	}
+	return toLowerFQDN(target), true
+}
+
+// resolveCNAMEs appends to crrs the records resolved for the targets of CNAME
+// and SVCB or HTTPS alias mode records for qname in crrs.
 func (r *Resolver) resolveCNAMEs(ctx context.Context, qname, qtype string, crrs RRs, depth int) (RRs, error) {
 	var rrs RRs
 	for _, crr := range crrs {
 		rrs = append(rrs, crr)
-		if crr.Type != "CNAME" || crr.Name != qname {
+		if crr.Name != qname {
 			continue
 		}
+		target := crr.Value
+		if crr.Type != "CNAME" {
+			var ok bool
+			if target, ok = svcbAlias(crr, qtype); !ok {
+				continue
+			}
+		}
 		logCNAME(crr.String(), depth)
-		crrs, _ := r.resolve(ctx, crr.Value, qtype, depth)
+		r.events.send(Event{Type: EventCNAME, Name: qname, Qtype: qtype, Depth: depth, Target: target})
+		crrs, err := r.resolve(ctx, target, qtype, depth)
+		if errors.Is(err, ErrMaxRecursion) {
+			return nil, err
+		}
 		for _, rr := range crrs {
+			rr = chained(rr, crr)
 			r.cache.add(qname, rr)
 			rrs = append(rrs, rr)
 		}
@@ -439,8 +2175,47 @@ func (r *Resolver) resolveCNAMEs(ctx context.Context, qname, qtype string, crrs
 	return rrs, nil
 }
 
-// saveDNSRR saves 1 or more DNS records to the resolver cache.
-func (r *Resolver) saveDNSRR(host, qname string, drrs []dns.RR) RRs {
+// chained returns rr, resolved via the CNAME or alias crr, with its expiry
+// capped at that of crr, so records served via crr from the cache expire
+// no later than crr does. The TTL of rr is unchanged.
+func chained(rr, crr RR) RR {
+	if !crr.Expiry.IsZero() && (rr.Expiry.IsZero() || crr.Expiry.Before(rr.Expiry)) {
+		rr.Expiry = crr.Expiry
+	}
+	return rr
+}
+
+// hasSOA reports whether drrs contains an SOA record.
+func hasSOA(drrs []dns.RR) bool {
+	for _, drr := range drrs {
+		if _, ok := drr.(*dns.SOA); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// negativeExpiry returns the expiry time of a negative (NXDOMAIN or NODATA)
+// response with authority section ns, or zero if r does not expire records.
+// Per RFC 2308, the negative TTL is the lesser of the TTL and MINIMUM field
+// of the SOA record. Responses without an SOA record expire immediately.
+func (r *Resolver) negativeExpiry(ns []dns.RR) time.Time {
+	if !r.expire {
+		return time.Time{}
+	}
+	now := time.Now()
+	for _, drr := range ns {
+		if soa, ok := drr.(*dns.SOA); ok {
+			ttl := min(soa.Hdr.Ttl, soa.Minttl)
+			return now.Add(time.Duration(ttl) * time.Second)
+		}
+	}
+	return now
+}
+
+// saveDNSRR saves 1 or more DNS records to the resolver cache,
+// marking them authoritative if authoritative is true.
+func (r *Resolver) saveDNSRR(host, qname string, drrs []dns.RR, authoritative bool) RRs {
 	var rrs RRs
 	cl := dns.CountLabel(qname)
 	for _, drr := range drrs {
@@ -452,7 +2227,8 @@ func (r *Resolver) saveDNSRR(host, qname string, drrs []dns.RR) RRs {
 			// fmt.Fprintf(os.Stderr, "Warning: potential poisoning from %s: %s -> %s\n", host, qname, drr.String())
 			continue
 		}
-		r.cache.add(rr.Name, rr)
+		rr.Authoritative = authoritative
+		r.cache.addRR(rr.Name, rr, drr, r.rawRecords || r.dnssec)
 		if rr.Name != qname {
 			continue
 		}
@@ -461,6 +2237,21 @@ func (r *Resolver) saveDNSRR(host, qname string, drrs []dns.RR) RRs {
 	return rrs
 }
 
+// staleLookup returns the cached delegation records of type qtype for qname,
+// including records expired within the stale delegation grace period, or nil.
+func (r *Resolver) staleLookup(qname, qtype string) RRs {
+	if r.staleGrace <= 0 || !r.expire {
+		return nil
+	}
+	var rrs RRs
+	for _, rr := range r.cache.getAt(qname, time.Now().Add(-r.staleGrace)) {
+		if rr.Type == qtype {
+			rrs = append(rrs, rr)
+		}
+	}
+	return r.shuffle(rrs)
+}
+
 // cacheGet returns a randomly ordered slice of DNS records.
 func (r *Resolver) cacheGet(ctx context.Context, qname, qtype string) (RRs, error) {
 	select {
@@ -468,14 +2259,20 @@ func (r *Resolver) cacheGet(ctx context.Context, qname, qtype string) (RRs, erro
 		return nil, ctx.Err()
 	default:
 	}
+	return r.cacheLookup(qname, qtype)
+}
+
+// cacheLookup is like cacheGet, but does not check for context cancellation.
+func (r *Resolver) cacheLookup(qname, qtype string) (RRs, error) {
 	any := r.cache.get(qname)
 	if any == nil {
-		any = rootCache.get(qname)
-	}
-	if any == nil {
-		return nil, nil
+		any = r.root.get(qname)
+	} else if qtype != "" && !slices.ContainsFunc(any, func(rr RR) bool { return rr.Type == qtype }) {
+		// Cached records of other types, such as the root DNSKEY records,
+		// don’t hide the root hints
+		any = append(slices.Clip(any), r.root.get(qname)...)
 	}
-	if len(any) == 0 {
+	if any != nil && len(any) == 0 {
 		return nil, NXDOMAIN
 	}
 	rrs := make(RRs, 0, len(any))
@@ -484,8 +2281,100 @@ func (r *Resolver) cacheGet(ctx context.Context, qname, qtype string) (RRs, erro
 			rrs = append(rrs, rr)
 		}
 	}
-	if len(rrs) == 0 && (qtype != "" && qtype != "NS") {
+	if len(rrs) == 0 {
+		if qtype != "" && r.cache.hasNoData(qname, qtype) {
+			return emptyRRs, nil
+		}
 		return nil, nil
 	}
-	return rrs, nil
+	rrs = preferAuthoritative(rrs)
+	if r.roundRobin {
+		// Rotated once per resolution by results
+		return rrs, nil
+	}
+	return r.shuffle(rrs), nil
+}
+
+// shuffle sorts and shuffles rrs in place with the Resolver source of randomness.
+func (r *Resolver) shuffle(rrs RRs) RRs {
+	slices.SortFunc(rrs, compareRRs)
+	r.rand.shuffle(len(rrs), func(i, j int) { rrs[i], rrs[j] = rrs[j], rrs[i] })
+	return rrs
+}
+
+// rotate returns a copy of rrs with the records of each RRset sorted, then
+// rotated by the number of previous resolutions of qname. Each RRset keeps
+// the positions of its records in rrs.
+func (r *Resolver) rotate(qname string, rrs RRs) RRs {
+	if len(rrs) < 2 {
+		return rrs
+	}
+	n := r.cache.rotate(qname)
+	rrs = slices.Clone(rrs)
+	sets := make(map[[2]string][]int)
+	for i, rr := range rrs {
+		k := [2]string{rr.Name, rr.Type}
+		sets[k] = append(sets[k], i)
+	}
+	for _, idx := range sets {
+		if len(idx) < 2 {
+			continue
+		}
+		set := make(RRs, len(idx))
+		for i, j := range idx {
+			set[i] = rrs[j]
+		}
+		slices.SortFunc(set, compareRRs)
+		for i, j := range idx {
+			rrs[j] = set[(i+int(n%uint64(len(set))))%len(set)]
+		}
+	}
+	return rrs
+}
+
+// lockedRand is a rand.Rand safe for concurrent use.
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+// newLockedRand returns a lockedRand seeded from crypto/rand.
+func newLockedRand() *lockedRand {
+	var seed [8]byte
+	crand.Read(seed[:])
+	return &lockedRand{r: rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(seed[:]))))}
+}
+
+func (lr *lockedRand) shuffle(n int, swap func(i, j int)) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	lr.r.Shuffle(n, swap)
+}
+
+func (lr *lockedRand) uint64() uint64 {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	return lr.r.Uint64()
+}
+
+// preferAuthoritative removes non-authoritative records from rrs
+// where an authoritative record with the same name and type is present.
+// It modifies rrs in place.
+func preferAuthoritative(rrs RRs) RRs {
+	type nameType struct{ Name, Type string }
+	var auth map[nameType]bool
+	for _, rr := range rrs {
+		if rr.Authoritative {
+			if auth == nil {
+				auth = make(map[nameType]bool)
+			}
+			auth[nameType{rr.Name, rr.Type}] = true
+		}
+	}
+	if auth == nil {
+		return rrs
+	}
+	return slices.DeleteFunc(rrs, func(rr RR) bool {
+		return !rr.Authoritative && auth[nameType{rr.Name, rr.Type}]
+	})
 }
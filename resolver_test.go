@@ -2,14 +2,24 @@ package dnsr
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
+	"math/rand"
 	"net"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/domainr/dnsr/dnsrtest"
+	"github.com/miekg/dns"
 	"github.com/nbio/st"
+	"golang.org/x/net/proxy"
 )
 
 func TestMain(m *testing.M) {
@@ -45,6 +55,1091 @@ func TestWithTimeout(t *testing.T) {
 	st.Expect(t, r.timeout, 99*time.Second)
 }
 
+func TestLargeUDPResponse(t *testing.T) {
+	txt := strings.Repeat("x", 250)
+	for _, truncate := range []bool{false, true} {
+		truncate := truncate
+		zone := newTestZone(t, testZoneRecords...)
+		s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			m := zone.reply(req)
+			if req.Question[0].Name == "example.com." && req.Question[0].Qtype == dns.TypeTXT {
+				for i := 0; i < 8; i++ {
+					m.Answer = append(m.Answer, &dns.TXT{
+						Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 300},
+						Txt: []string{fmt.Sprintf("%d%s", i, txt)},
+					})
+				}
+				m.Truncated = truncate && w.RemoteAddr().Network() == "udp"
+			}
+			w.WriteMsg(m)
+		}))
+		d := s.Dialer()
+		r := NewResolver(WithDialer(d), WithTCPRetry())
+		rrs, err := r.ResolveErr("example.com", "TXT")
+		st.Expect(t, err, nil)
+		st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "TXT" }), 8)
+		dials := d.Dials()
+		st.Expect(t, strings.HasPrefix(dials[len(dials)-1], "tcp "), truncate)
+	}
+}
+
+func TestWithMaxTXTBytes(t *testing.T) {
+	records := append([]string{
+		`example.com. 300 IN TXT "` + strings.Repeat("x", 255) + `" "` + strings.Repeat("y", 255) + `"`,
+		`example.com. 300 IN TXT "` + strings.Repeat("x", 255) + `" "` + strings.Repeat("z", 255) + `"`,
+		`example.com. 300 IN TXT "short"`,
+	}, testZoneRecords...)
+	r, _ := newTestResolver(t, records, WithMaxTXTBytes(16), WithTCPRetry())
+	rrs, err := r.ResolveErr("example.com", "TXT")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "TXT" && rr.Value == strings.Repeat("x", 16)+"..." }), 2)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "TXT" && rr.Value == "short" }), 1)
+
+	// Records sharing a prefix are cached whole and returned whole by ResolveRaw
+	rrs, ok := r.ResolveCached("example.com", "TXT")
+	st.Expect(t, ok, true)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "TXT" && rr.Value == strings.Repeat("x", 16)+"..." }), 2)
+	drrs, err := r.ResolveRaw(context.Background(), "example.com", "TXT")
+	st.Expect(t, err, nil)
+	n := 0
+	for _, drr := range drrs {
+		if txt, ok := drr.(*dns.TXT); ok && len(strings.Join(txt.Txt, "")) == 510 {
+			n++
+		}
+	}
+	st.Expect(t, n, 2)
+}
+
+func TestWithTCPFallbackOn(t *testing.T) {
+	zone := newTestZone(t, testZoneRecords...)
+	s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := zone.reply(req)
+		if w.RemoteAddr().Network() == "udp" && req.Question[0].Name == "example.com." {
+			m = new(dns.Msg)
+			m.SetRcode(req, dns.RcodeServerFailure)
+		}
+		w.WriteMsg(m)
+	}))
+	for _, options := range [][]Option{nil, {WithTCPFallbackOn(dns.RcodeServerFailure)}} {
+		d := s.Dialer()
+		r := NewResolver(append([]Option{WithDialer(d)}, options...)...)
+		rrs, err := r.ResolveErr("example.com", "A")
+		if options == nil {
+			st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" }), 0)
+			continue
+		}
+		st.Expect(t, err, nil)
+		st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" }), 1)
+		dials := d.Dials()
+		st.Expect(t, strings.HasPrefix(dials[len(dials)-1], "tcp "), true)
+	}
+}
+
+func TestMaxIPsAcrossFamilies(t *testing.T) {
+	records := []string{
+		"com. 172800 IN NS a.gtld-servers.net.",
+		"a.gtld-servers.net. 172800 IN A 192.0.2.1",
+		"com. 900 IN SOA a.gtld-servers.net. nstld.verisign-grs.com. 1 1800 900 604800 86400",
+		"example.com. 172800 IN NS ns1.example.com.",
+		"ns1.example.com. 172800 IN A 192.0.2.53",
+		"ns1.example.com. 172800 IN A 192.0.2.54",
+		"ns1.example.com. 172800 IN A 192.0.2.55",
+		"ns1.example.com. 172800 IN AAAA 2001:db8::53",
+		"ns1.example.com. 172800 IN AAAA 2001:db8::54",
+		"ns1.example.com. 172800 IN AAAA 2001:db8::55",
+		"example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300",
+		"example.com. 300 IN A 203.0.113.1",
+	}
+	for _, fam := range []AddressFamily{IPv4Only, DualStack} {
+		r, d := newTestResolver(t, records)
+		r.family = fam
+		d.Unreachable = func(addr string) bool {
+			host, _, _ := net.SplitHostPort(addr)
+			return strings.HasPrefix(host, "192.0.2.5") || strings.HasPrefix(host, "2001:db8::")
+		}
+		r.ResolveErr("example.com", "A")
+
+		// The first exchange with ns1.example.com tries MaxIPs addresses
+		var attempted []string
+		for _, dial := range d.Dials() {
+			_, addr, _ := strings.Cut(dial, " ")
+			host, _, _ := net.SplitHostPort(addr)
+			if d.Unreachable(addr) && (len(attempted) == 0 || attempted[len(attempted)-1] != host) {
+				attempted = append(attempted, host)
+			}
+		}
+		st.Expect(t, len(attempted) >= MaxIPs, true)
+		v6 := 0
+		for _, host := range attempted[:MaxIPs] {
+			if strings.Contains(host, ":") {
+				v6++
+			}
+		}
+		if fam == DualStack {
+			st.Expect(t, v6, 1)
+		} else {
+			st.Expect(t, v6, 0)
+		}
+	}
+}
+
+func TestWithIPv6(t *testing.T) {
+	records := []string{
+		"com. 172800 IN NS a.gtld-servers.net.",
+		"a.gtld-servers.net. 172800 IN A 192.0.2.1",
+		"example.com. 172800 IN NS ns1.example.com.",
+		"ns1.example.com. 172800 IN AAAA 2001:db8::53",
+		"example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300",
+		"example.com. 300 IN A 203.0.113.1",
+	}
+	r, d := newTestResolver(t, records, WithIPv6())
+	rrs, err := r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" && rr.Value == "203.0.113.1" }), 1)
+	isV6 := func(dial string) bool { return strings.HasSuffix(dial, " [2001:db8::53]:53") }
+	st.Expect(t, slices.ContainsFunc(d.Dials(), isV6), true)
+
+	r, d = newTestResolver(t, records)
+	r.ResolveErr("example.com", "A")
+	st.Expect(t, slices.ContainsFunc(d.Dials(), isV6), false)
+}
+
+func TestWithAddressFamily(t *testing.T) {
+	records := []string{
+		"com. 172800 IN NS a.gtld-servers.net.",
+		"a.gtld-servers.net. 172800 IN A 192.0.2.1",
+		"a.gtld-servers.net. 172800 IN AAAA 2001:db8::1",
+		"example.com. 172800 IN NS ns1.example.com.",
+		"ns1.example.com. 172800 IN A 192.0.2.53",
+		"ns1.example.com. 172800 IN AAAA 2001:db8::53",
+		"example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300",
+		"example.com. 300 IN A 203.0.113.1",
+	}
+	tests := []struct {
+		fam      AddressFamily
+		v4, v6   bool // families that may be dialed
+		families int  // families dialed for ns1.example.com
+	}{
+		{IPv4Only, true, false, 1},
+		{IPv6Only, false, true, 1},
+		{DualStack, true, true, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fam.String(), func(t *testing.T) {
+			r, d := newTestResolver(t, records, WithAddressFamily(tt.fam))
+			d.Unreachable = func(addr string) bool {
+				host, _, _ := net.SplitHostPort(addr)
+				return host == "192.0.2.53" || host == "2001:db8::53"
+			}
+			r.ResolveErr("example.com", "A")
+			seen := make(map[bool]bool)
+			for _, dial := range d.Dials() {
+				_, addr, _ := strings.Cut(dial, " ")
+				host, _, _ := net.SplitHostPort(addr)
+				v6 := strings.Contains(host, ":")
+				if v6 {
+					st.Expect(t, tt.v6, true)
+				} else {
+					st.Expect(t, tt.v4, true)
+				}
+				if host == "192.0.2.53" || host == "2001:db8::53" {
+					seen[v6] = true
+				}
+			}
+			st.Expect(t, len(seen), tt.families)
+		})
+	}
+}
+
+func TestWithHappyEyeballs(t *testing.T) {
+	records := []string{
+		"com. 172800 IN NS a.gtld-servers.net.",
+		"a.gtld-servers.net. 172800 IN A 192.0.2.1",
+		"example.com. 172800 IN NS ns1.example.com.",
+		"ns1.example.com. 172800 IN A 192.0.2.53",
+		"ns1.example.com. 172800 IN AAAA 2001:db8::53",
+		"example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300",
+		"example.com. 300 IN A 203.0.113.1",
+	}
+	ns1 := func(dials []string) []string {
+		var addrs []string
+		for _, dial := range dials {
+			_, addr, _ := strings.Cut(dial, " ")
+			if host, _, _ := net.SplitHostPort(addr); host == "192.0.2.53" || host == "2001:db8::53" {
+				addrs = append(addrs, host)
+			}
+		}
+		return addrs
+	}
+
+	// Slow IPv6, fast IPv4
+	r, d := newTestResolver(t, records, WithTimeout(10*time.Second), WithIPv6(), WithHappyEyeballs())
+	d.Blackholed = func(addr string) bool {
+		return strings.HasPrefix(addr, "[2001:db8::53]:")
+	}
+	start := time.Now()
+	rrs, err := r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" }), 1)
+	st.Expect(t, time.Since(start) < time.Second, true)
+	addrs := ns1(d.Dials())
+	st.Assert(t, len(addrs) >= 2, true)
+	st.Expect(t, addrs[:2], []string{"2001:db8::53", "192.0.2.53"})
+
+	// Fast IPv6
+	r, d = newTestResolver(t, records, WithIPv6(), WithHappyEyeballs())
+	rrs, err = r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" }), 1)
+	addrs = ns1(d.Dials())
+	st.Expect(t, len(addrs) > 0, true)
+	st.Expect(t, slices.Contains(addrs, "192.0.2.53"), false)
+}
+
+// newTestForwarderResolver returns a Resolver whose name servers answer
+// the first n queries for example.com A recursively, like a forwarder.
+func newTestForwarderResolver(t *testing.T, n int, options ...Option) *Resolver {
+	zone := newTestZone(t, testZoneRecords...)
+	var mu sync.Mutex
+	s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := zone.reply(req)
+		if q := req.Question[0]; q.Name == "example.com." && q.Qtype == dns.TypeA {
+			mu.Lock()
+			if n > 0 {
+				n--
+				m.Authoritative = false
+				m.RecursionAvailable = true
+				rr, _ := dns.NewRR("example.com. 300 IN A 198.51.100.1")
+				m.Answer = []dns.RR{rr}
+			}
+			mu.Unlock()
+		}
+		w.WriteMsg(m)
+	}))
+	return NewResolver(append([]Option{WithDialer(s.Dialer())}, options...)...)
+}
+
+func TestWithRejectRecursedAnswers(t *testing.T) {
+	isA := func(value string) func(RR) bool {
+		return func(rr RR) bool { return rr.Name == "example.com." && rr.Type == "A" && rr.Value == value }
+	}
+	r := newTestForwarderResolver(t, 100)
+	rrs, err := r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, isA("198.51.100.1")), 1)
+
+	r = newTestForwarderResolver(t, 100, WithRejectRecursedAnswers())
+	rrs, err = r.ResolveErr("example.com", "A")
+	st.Expect(t, count(rrs, isA("198.51.100.1")), 0)
+
+	r = newTestForwarderResolver(t, 1, WithRejectRecursedAnswers())
+	rrs, err = r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, isA("198.51.100.1")), 0)
+	st.Expect(t, count(rrs, isA("203.0.113.1")), 1)
+}
+
+func TestWithQueryModifier(t *testing.T) {
+	zone := newTestZone(t, testZoneRecords...)
+	var mu sync.Mutex
+	var queries, modified int
+	s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		mu.Lock()
+		queries++
+		if req.CheckingDisabled && req.IsEdns0() != nil {
+			modified++
+		}
+		mu.Unlock()
+		w.WriteMsg(zone.reply(req))
+	}))
+	r := NewResolver(WithDialer(s.Dialer()), WithQueryModifier(func(m *dns.Msg) {
+		m.CheckingDisabled = true
+		m.SetEdns0(1232, false)
+	}))
+	rrs, err := r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" }), 1)
+	mu.Lock()
+	defer mu.Unlock()
+	st.Expect(t, queries > 0, true)
+	st.Expect(t, modified, queries)
+}
+
+func TestWithDialTimeout(t *testing.T) {
+	records := []string{
+		"com. 172800 IN NS a.gtld-servers.net.",
+		"a.gtld-servers.net. 172800 IN A 192.0.2.1",
+		"example.com. 172800 IN NS ns1.example.com.",
+		"ns1.example.com. 172800 IN A 192.0.2.53",
+		"ns1.example.com. 172800 IN A 192.0.2.54",
+		"example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300",
+		"example.com. 300 IN A 203.0.113.1",
+	}
+	r, d := newTestResolver(t, records, WithTimeout(10*time.Second), WithDialTimeout(50*time.Millisecond))
+	var mu sync.Mutex
+	var blackholed string // the first address dialed for ns1.example.com
+	d.Blackholed = func(addr string) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if blackholed == "" && (strings.HasPrefix(addr, "192.0.2.53:") || strings.HasPrefix(addr, "192.0.2.54:")) {
+			blackholed = addr
+		}
+		return addr == blackholed
+	}
+	start := time.Now()
+	rrs, err := r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" }), 1)
+	st.Expect(t, time.Since(start) < time.Second, true)
+	st.Expect(t, blackholed != "", true)
+}
+
+func TestResolveShared(t *testing.T) {
+	zone := newTestZone(t, testZoneRecords...)
+	release := make(chan struct{})
+	s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		<-release
+		w.WriteMsg(zone.reply(req))
+	}))
+	d := s.Dialer()
+	r := NewResolver(WithDialer(d), WithForwarder("192.0.2.53"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	canceled := make(chan error, 1)
+	go func() {
+		_, err := r.ResolveContext(ctx, "example.com", "A")
+		canceled <- err
+	}()
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rrs, err := r.ResolveErr("Example.com", "A")
+			if err == nil && count(rrs, func(rr RR) bool { return rr.Type == "A" }) != 1 {
+				err = fmt.Errorf("unexpected result: %v", rrs)
+			}
+			errs <- err
+		}()
+	}
+	for len(d.Dials()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond) // Let the callers join the shared lookup
+	cancel()
+	st.Reject(t, <-canceled, nil)
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		st.Expect(t, err, nil)
+	}
+	st.Expect(t, len(d.Dials()), 1)
+}
+
+func TestResolveSharedIterative(t *testing.T) {
+	_, d := newTestResolver(t, testZoneRecords)
+	_, err := NewResolver(WithDialer(d)).ResolveErr("example.com", "A")
+	st.Assert(t, err, nil)
+	walk := len(d.Dials())
+
+	zone := newTestZone(t, testZoneRecords...)
+	release := make(chan struct{})
+	s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		<-release
+		w.WriteMsg(zone.reply(req))
+	}))
+	d = s.Dialer()
+	r := NewResolver(WithDialer(d))
+	var wg sync.WaitGroup
+	details := make(chan Detail, 100)
+	errs := make(chan error, 100)
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, detail, err := r.ResolveContextDetail(context.Background(), "example.com", "A")
+			details <- detail
+			errs <- err
+		}()
+	}
+	for len(d.Dials()) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond) // Let the callers join the shared lookup
+	close(release)
+	wg.Wait()
+	close(details)
+	close(errs)
+	for err := range errs {
+		st.Expect(t, err, nil)
+	}
+	for detail := range details {
+		st.Expect(t, detail.FromCache, false)
+		st.Expect(t, detail.AnsweredBy != "", true)
+		st.Expect(t, detail.Nameservers > 0, true)
+		st.Expect(t, detail.Depth > 1, true)
+	}
+	st.Expect(t, len(d.Dials()), walk)
+}
+
+func TestWithForwarder(t *testing.T) {
+	zone := newTestZone(t, testZoneRecords...)
+	var mu sync.Mutex
+	var queries []string
+	s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		q := req.Question[0]
+		mu.Lock()
+		queries = append(queries, q.Name+" "+dns.TypeToString[q.Qtype])
+		mu.Unlock()
+		if !req.RecursionDesired {
+			m := new(dns.Msg)
+			m.SetRcode(req, dns.RcodeRefused)
+			w.WriteMsg(m)
+			return
+		}
+		// Answer recursively, following CNAMEs
+		m := zone.reply(req)
+		for i := 0; i < len(m.Answer); i++ {
+			if cname, ok := m.Answer[i].(*dns.CNAME); ok {
+				var creq dns.Msg
+				creq.SetQuestion(cname.Target, q.Qtype)
+				m.Answer = append(m.Answer, zone.reply(&creq).Answer...)
+			}
+		}
+		m.Authoritative = false
+		m.RecursionAvailable = true
+		m.Ns, m.Extra = nil, nil
+		w.WriteMsg(m)
+	}))
+	d := s.Dialer()
+	r := NewResolver(WithDialer(d), WithForwarder("192.0.2.250"), WithRejectRecursedAnswers())
+	rrs, err := r.ResolveErr("www.example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "CNAME" && rr.Value == "example.com." }) >= 1, true)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" && rr.Value == "203.0.113.1" }) >= 1, true)
+	mu.Lock()
+	st.Expect(t, queries, []string{"www.example.com. A"})
+	mu.Unlock()
+
+	_, err = r.ResolveErr("nx.example.com", "A")
+	st.Expect(t, err, NXDOMAIN)
+
+	// Cached
+	rrs, err = r.ResolveErr("www.example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" }) >= 1, true)
+	mu.Lock()
+	st.Expect(t, queries, []string{"www.example.com. A", "nx.example.com. A"})
+	mu.Unlock()
+
+	for _, dial := range d.Dials() {
+		st.Expect(t, strings.HasSuffix(dial, " 192.0.2.250:53"), true) // never the root
+	}
+
+	_, err = NewResolver(WithDialer(d), WithForwarder("resolver.example")).ResolveErr("example.com", "A")
+	st.Expect(t, err, ErrInvalidServer)
+}
+
+func TestWithPort(t *testing.T) {
+	records := []string{
+		"com. 172800 IN NS a.gtld-servers.net.",
+		"a.gtld-servers.net. 172800 IN A 127.0.0.1",
+		"example.com. 172800 IN NS ns1.example.com.",
+		"ns1.example.com. 172800 IN A 127.0.0.1",
+		"example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300",
+		"example.com. 300 IN A 203.0.113.1",
+	}
+	s := newTestServer(t, newTestZone(t, records...))
+	_, p, err := net.SplitHostPort(s.Addr)
+	st.Assert(t, err, nil)
+	port, err := strconv.Atoi(p)
+	st.Assert(t, err, nil)
+	root := WithRootServers([]string{"127.0.0.1"})
+	for _, options := range [][]Option{
+		{WithPort(port)},
+		{WithPort(port), WithTCPOnly()},
+		{WithPort(1), WithServerPort("127.0.0.1", port)},
+	} {
+		r := NewResolver(append([]Option{root}, options...)...)
+		rrs, err := r.ResolveErr("example.com", "A")
+		st.Expect(t, err, nil)
+		st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" && rr.Value == "203.0.113.1" }), 1)
+	}
+}
+
+func TestWithTLS(t *testing.T) {
+	addr, config := newTestTLSServer(t, newTestZone(t, testZoneRecords...), "dns.example")
+	d := &testDialer{addr: addr}
+	r := NewResolver(WithDialer(d), WithTLS(config))
+	rrs, err := r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" }), 1)
+	st.Expect(t, len(d.Dials()) > 0, true)
+	for _, dial := range d.Dials() {
+		network, addr, _ := strings.Cut(dial, " ")
+		_, port, _ := net.SplitHostPort(addr)
+		st.Expect(t, network, "tcp")
+		st.Expect(t, port, "853")
+	}
+
+	// Untrusted certificate
+	r = NewResolver(WithDialer(d), WithTLS(&tls.Config{ServerName: "dns.example"}))
+	_, err = r.ResolveErr("example.com", "A")
+	st.Expect(t, err != nil, true)
+}
+
+func TestWithTransport(t *testing.T) {
+	reply := func(answer string, extra ...string) *dns.Msg {
+		m := new(dns.Msg)
+		m.Authoritative = true
+		for i, s := range append([]string{answer}, extra...) {
+			rr, err := dns.NewRR(s)
+			st.Assert(t, err, nil)
+			if i == 0 {
+				m.Answer = append(m.Answer, rr)
+			} else {
+				m.Extra = append(m.Extra, rr)
+			}
+		}
+		return m
+	}
+	var tr dnsrtest.MemoryTransport
+	tr.Add("192.0.2.250", "com", "NS", reply("com. 172800 IN NS a.gtld-servers.net.", "a.gtld-servers.net. 172800 IN A 192.0.2.1"))
+	tr.Add("192.0.2.1", "example.com", "NS", reply("example.com. 172800 IN NS ns1.example.com.", "ns1.example.com. 172800 IN A 192.0.2.53"))
+	tr.Add("192.0.2.53", "example.com", "A", reply("example.com. 300 IN A 203.0.113.1"))
+	r := NewResolver(WithTransport(&tr), WithRootServers([]string{"192.0.2.250"}), WithDialer(failDialer{}))
+	rrs, err := r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" && rr.Value == "203.0.113.1" }), 1)
+	st.Expect(t, tr.Queries(), []string{
+		"192.0.2.250 com. NS",
+		"192.0.2.1 example.com. NS",
+		"192.0.2.53 example.com. A",
+	})
+}
+
+// failDialer is a ContextDialer that always fails.
+type failDialer struct{}
+
+func (failDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	return nil, errors.New("unexpected dial")
+}
+
+// expireCache sets the expiry of every record cached by r to expiry.
+func expireCache(r *Resolver, expiry time.Time) {
+	r.cache.m.Lock()
+	defer r.cache.m.Unlock()
+	for _, e := range r.cache.entries {
+		for k, rr := range e.rrs {
+			rr.Expiry = expiry
+			e.rrs[k] = rr
+		}
+		for qtype := range e.nodata {
+			e.nodata[qtype] = expiry
+		}
+		if e.nx() {
+			e.nxExpiry = expiry
+		}
+	}
+}
+
+func TestNegativeCacheTTL(t *testing.T) {
+	tests := []struct {
+		soa string
+		ttl time.Duration
+	}{
+		{"example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300", 300 * time.Second},
+		{"example.com. 60 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300", 60 * time.Second},
+	}
+	for _, tt := range tests {
+		records := append([]string{tt.soa}, slices.DeleteFunc(slices.Clone(testZoneRecords), func(s string) bool {
+			return strings.HasPrefix(s, "example.com. 3600 IN SOA")
+		})...)
+		r, d := newTestResolver(t, records, WithExpiry())
+		start := time.Now()
+		_, err := r.ResolveErr("nx.example.com", "A")
+		st.Expect(t, err, NXDOMAIN)
+		rrs, err := r.ResolveErr("example.com", "MX")
+		st.Expect(t, err, nil)
+		st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "MX" }), 0)
+
+		r.cache.m.RLock()
+		nxExpiry := r.cache.entries["nx.example.com."].nxExpiry
+		noDataExpiry := r.cache.entries["example.com."].nodata["MX"]
+		r.cache.m.RUnlock()
+		for _, expiry := range []time.Time{nxExpiry, noDataExpiry} {
+			st.Expect(t, !expiry.Before(start.Add(tt.ttl)), true)
+			st.Expect(t, expiry.Before(time.Now().Add(tt.ttl)), true)
+		}
+
+		n := len(d.Dials())
+		_, err = r.ResolveErr("nx.example.com", "A")
+		st.Expect(t, err, NXDOMAIN)
+		st.Expect(t, len(d.Dials()), n)
+
+		expireCache(r, time.Now().Add(-time.Minute))
+		_, ok := r.ResolveCached("nx.example.com", "A")
+		st.Expect(t, ok, false)
+		_, ok = r.ResolveCached("example.com", "MX")
+		st.Expect(t, ok, false)
+		_, err = r.ResolveErr("nx.example.com", "A")
+		st.Expect(t, err, NXDOMAIN)
+		st.Expect(t, len(d.Dials()) > n, true)
+	}
+
+	r, _ := newTestResolver(t, testZoneRecords)
+	_, err := r.ResolveErr("nx.example.com", "A")
+	st.Expect(t, err, NXDOMAIN)
+	r.cache.m.RLock()
+	nxExpiry := r.cache.entries["nx.example.com."].nxExpiry
+	r.cache.m.RUnlock()
+	st.Expect(t, nxExpiry, time.Time{})
+}
+
+func TestWithStaleDelegation(t *testing.T) {
+	for _, grace := range []time.Duration{0, time.Hour} {
+		r, d := newTestResolver(t, testZoneRecords, WithExpiry(), WithStaleDelegation(grace))
+		_, err := r.ResolveErr("example.com", "A")
+		st.Expect(t, err, nil)
+		expireCache(r, time.Now().Add(-time.Minute))
+		n := len(d.Dials())
+		rrs, err := r.ResolveErr("example.com", "A")
+		st.Expect(t, err, nil)
+		st.Expect(t, count(rrs, func(rr RR) bool { return rr.Name == "example.com." && rr.Type == "A" }) >= 1, true)
+		if grace > 0 {
+			// Only the answer, from the example.com name servers
+			for _, dial := range d.Dials()[n:] {
+				st.Expect(t, strings.Contains(dial, " 192.0.2.5"), true)
+			}
+		} else {
+			st.Expect(t, len(d.Dials())-n > 1, true)
+		}
+	}
+
+	// Outside the grace period
+	r, d := newTestResolver(t, testZoneRecords, WithExpiry(), WithStaleDelegation(time.Minute))
+	_, err := r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	expireCache(r, time.Now().Add(-time.Hour))
+	n := len(d.Dials())
+	_, err = r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, len(d.Dials())-n > 1, true)
+}
+
+func TestWithTCPOnly(t *testing.T) {
+	r, d := newTestResolver(t, testZoneRecords, WithTCPOnly(), WithTCPRetry())
+	rrs, err := r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" }), 1)
+	for _, dial := range d.Dials() {
+		st.Expect(t, strings.HasPrefix(dial, "tcp "), true)
+	}
+}
+
+func TestWithEDNSPaddingKeepalive(t *testing.T) {
+	zone := newTestZone(t, testZoneRecords...)
+	var mu sync.Mutex
+	var queries []*dns.Msg
+	var networks []string
+	s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		mu.Lock()
+		queries = append(queries, req)
+		networks = append(networks, w.RemoteAddr().Network())
+		mu.Unlock()
+		w.WriteMsg(zone.reply(req))
+	}))
+	options := func(m *dns.Msg) map[uint16]bool {
+		opts := make(map[uint16]bool)
+		if opt := m.IsEdns0(); opt != nil {
+			for _, o := range opt.Option {
+				opts[o.Option()] = true
+			}
+		}
+		return opts
+	}
+
+	r := NewResolver(WithDialer(s.Dialer()), WithEDNSPadding(), WithEDNSKeepalive())
+	_, err := r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	mu.Lock()
+	st.Expect(t, len(queries) > 0, true)
+	for i, q := range queries {
+		st.Expect(t, networks[i], "udp")
+		st.Expect(t, q.IsEdns0(), (*dns.OPT)(nil))
+	}
+	queries, networks = nil, nil
+	mu.Unlock()
+
+	r = NewResolver(WithDialer(s.Dialer()), WithTCPOnly(), WithEDNSPadding(), WithEDNSKeepalive())
+	_, err = r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	mu.Lock()
+	defer mu.Unlock()
+	st.Expect(t, len(queries) > 0, true)
+	for i, q := range queries {
+		st.Expect(t, networks[i], "tcp")
+		st.Expect(t, options(q), map[uint16]bool{dns.EDNS0TCPKEEPALIVE: true, dns.EDNS0PADDING: true})
+		st.Expect(t, q.Len()%128, 0)
+	}
+}
+
+func TestSOCKS5(t *testing.T) {
+	s := newTestServer(t, newTestZone(t, testZoneRecords...))
+	p := newTestSOCKSServer(t, s.Addr)
+	pd, err := proxy.SOCKS5("tcp", p.Addr, nil, proxy.Direct)
+	st.Assert(t, err, nil)
+	d := pd.(proxy.ContextDialer)
+
+	// The proxy dialer doesn’t support UDP, so queries fall back to TCP
+	for _, options := range [][]Option{nil, {WithTCPOnly()}} {
+		n := len(p.Conns())
+		r := NewResolver(append([]Option{WithDialer(d), WithRootServers([]string{"192.0.2.250"})}, options...)...)
+		rrs, err := r.ResolveErr("example.com", "A")
+		st.Expect(t, err, nil)
+		st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" }), 1)
+		conns := p.Conns()[n:]
+		st.Expect(t, len(conns) >= 3, true) // root, com, example.com
+		st.Expect(t, conns[0], "192.0.2.250:53")
+	}
+}
+
+func TestDialFallbackContext(t *testing.T) {
+	// Dials failing because the context is done don’t fall back to TCP
+	r, d := newTestResolver(t, testZoneRecords, WithTimeout(time.Second), WithDialTimeout(20*time.Millisecond))
+	d.Blackholed = func(string) bool { return true }
+	_, err := r.ResolveErr("example.com", "A")
+	st.Reject(t, err, nil)
+	dials := d.Dials()
+	st.Expect(t, len(dials) > 0, true)
+	st.Expect(t, slices.ContainsFunc(dials, func(dial string) bool { return strings.HasPrefix(dial, "tcp ") }), false)
+
+	r, d = newTestResolver(t, testZoneRecords)
+	d.Blackholed = func(string) bool { return true }
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err = r.ResolveContext(ctx, "example.com", "A")
+	st.Reject(t, err, nil)
+	dials = d.Dials()
+	st.Expect(t, len(dials) > 0, true)
+	st.Expect(t, slices.ContainsFunc(dials, func(dial string) bool { return strings.HasPrefix(dial, "tcp ") }), false)
+}
+
+func TestWithAnswerOnly(t *testing.T) {
+	r := NewResolver(WithAnswerOnly())
+	st.Expect(t, r.answerOnly, true)
+}
+
+func TestAnswers(t *testing.T) {
+	rrs := RRs{
+		{Name: "www.example.com.", Type: "CNAME", Value: "web.example.com."},
+		{Name: "web.example.com.", Type: "CNAME", Value: "example.net."},
+		{Name: "example.net.", Type: "A", Value: "203.0.113.1"},
+		{Name: "example.net.", Type: "AAAA", Value: "2001:db8::1"},
+		{Name: "www.example.com.", Type: "NS", Value: "ns1.example.com."},
+		{Name: "ns1.example.com.", Type: "A", Value: "203.0.113.53"},
+	}
+	st.Expect(t, answers("www.example.com.", "A", rrs), rrs[:3])
+	st.Expect(t, answers("www.example.com.", "", rrs), rrs[:5])
+	st.Expect(t, answers("example.net.", "AAAA", rrs), RRs{rrs[3]})
+	st.Expect(t, answers("example.org.", "A", rrs), RRs{})
+	st.Expect(t, answers("example.org.", "A", nil), RRs(nil))
+}
+
+func TestWithRootConcurrency(t *testing.T) {
+	r := NewResolver()
+	st.Expect(t, cap(r.rootSem), RootConcurrency)
+	r = NewResolver(WithRootConcurrency(5))
+	st.Expect(t, cap(r.rootSem), 5)
+
+	var records []string
+	for i := 0; i < 8; i++ {
+		records = append(records,
+			fmt.Sprintf("tld%d. 3600 IN NS ns.tld%d.", i, i),
+			fmt.Sprintf("ns.tld%d. 3600 IN A 192.0.2.%d", i, i+1))
+	}
+	z := newTestZone(t, records...)
+	for _, n := range []int{1, 3} {
+		var mu sync.Mutex
+		var inflight, max int
+		s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			mu.Lock()
+			if inflight++; inflight > max {
+				max = inflight
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			inflight--
+			mu.Unlock()
+			z.ServeDNS(w, req)
+		}))
+		r := NewResolver(WithDialer(s.Dialer()), WithRootConcurrency(n))
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(tld string) {
+				defer wg.Done()
+				_, err := r.ResolveErr(tld, "NS")
+				st.Expect(t, err, nil)
+			}(fmt.Sprintf("tld%d", i))
+		}
+		wg.Wait()
+		mu.Lock()
+		st.Expect(t, max >= 1 && max <= n, true)
+		mu.Unlock()
+	}
+}
+
+func TestWithCacheNoData(t *testing.T) {
+	r, d := newTestResolver(t, testZoneRecords)
+	st.Expect(t, r.cacheNoData, true)
+	_, err := r.ResolveErr("example.com", "MX")
+	st.Expect(t, err, nil)
+	n := len(d.Dials())
+	rrs, err := r.ResolveErr("example.com", "MX")
+	st.Expect(t, err, nil)
+	st.Expect(t, rrs, emptyRRs)
+	st.Expect(t, len(d.Dials()), n)
+
+	r, d = newTestResolver(t, testZoneRecords, WithCacheNoData(false))
+	st.Expect(t, r.cacheNoData, false)
+	_, err = r.ResolveErr("example.com", "MX")
+	st.Expect(t, err, nil)
+	n = len(d.Dials())
+	_, err = r.ResolveErr("example.com", "MX")
+	st.Expect(t, err, nil)
+	st.Expect(t, len(d.Dials()) > n, true)
+
+	// NXDOMAIN is cached regardless
+	_, err = r.ResolveErr("nx.example.com", "A")
+	st.Expect(t, err, NXDOMAIN)
+	n = len(d.Dials())
+	_, err = r.ResolveErr("nx.example.com", "A")
+	st.Expect(t, err, NXDOMAIN)
+	st.Expect(t, len(d.Dials()), n)
+}
+
+func TestWithIncludeNegativeSOA(t *testing.T) {
+	isSOA := func(rr RR) bool { return rr.Name == "example.com." && rr.Type == "SOA" }
+	r, _ := newTestResolver(t, testZoneRecords, WithIncludeNegativeSOA())
+	for i := 0; i < 2; i++ { // uncached and cached
+		rrs, err := r.ResolveErr("ns1.example.com", "MX")
+		st.Expect(t, err, nil)
+		st.Expect(t, count(rrs, isSOA), 1)
+		rrs, err = r.ResolveErr("nx.example.com", "A")
+		st.Expect(t, err, NXDOMAIN)
+		st.Expect(t, count(rrs, isSOA), 1)
+	}
+	rrs, err := r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, isSOA), 0)
+
+	r, _ = newTestResolver(t, testZoneRecords)
+	rrs, err = r.ResolveErr("ns1.example.com", "MX")
+	st.Expect(t, err, nil)
+	st.Expect(t, len(rrs), 0)
+	rrs, err = r.ResolveErr("nx.example.com", "A")
+	st.Expect(t, err, NXDOMAIN)
+	st.Expect(t, len(rrs), 0)
+}
+
+func TestWithoutSOA(t *testing.T) {
+	isSOA := func(rr RR) bool { return rr.Type == "SOA" }
+	r, _ := newTestResolver(t, testZoneRecords)
+	r.ResolveErr("example.com", "MX") // caches the SOA from the NODATA response
+	rrs, err := r.ResolveErr("example.com", "")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, isSOA), 1)
+
+	r, _ = newTestResolver(t, testZoneRecords, WithoutSOA())
+	rrs, err = r.ResolveErr("example.com", "MX")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, isSOA), 0)
+	rrs, err = r.ResolveErr("example.com", "")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, isSOA), 0)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "NS" }), 2)
+	rrs, err = r.ResolveErr("example.com", "SOA")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, isSOA), 1)
+}
+
+func TestWithoutGlue(t *testing.T) {
+	isGlue := func(rr RR) bool { return rr.Type == "A" && strings.HasPrefix(rr.Name, "ns") }
+	r, _ := newTestResolver(t, testZoneRecords)
+	rrs, err := r.ResolveErr("example.com", "NS")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, isGlue), 2)
+
+	r, _ = newTestResolver(t, testZoneRecords, WithoutGlue())
+	rrs, err = r.ResolveErr("example.com", "NS")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, isGlue), 0)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "NS" }), 2)
+	rrs, err = r.ResolveErr("ns1.example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, rrs, RRs{{Name: "ns1.example.com.", Type: "A", Value: "192.0.2.53", TTL: 172800 * time.Second}})
+
+	rrs = RRs{
+		{Name: "example.com.", Type: "NS", Value: "example.com."},
+		{Name: "example.com.", Type: "A", Value: "203.0.113.1"},
+		{Name: "example.com.", Type: "NS", Value: "ns1.example.net."},
+		{Name: "ns1.example.net.", Type: "AAAA", Value: "2001:db8::53"},
+	}
+	st.Expect(t, withoutGlue("example.com.", rrs), rrs[:3])
+}
+
+func TestWithNoDataError(t *testing.T) {
+	st.Expect(t, ErrNXDomain, NXDOMAIN)
+	records := append([]string{
+		"dangling.example.com. 300 IN CNAME nowhere.example.com.",
+		"lame.example.com. 300 IN NS ns1.example.com.",
+	}, testZoneRecords...)
+	r, _ := newTestResolver(t, records, WithNoDataError())
+	tests := []struct {
+		qname, qtype string
+		err          error
+	}{
+		{"example.com", "A", nil},
+		{"example.com", "MX", ErrNoData},
+		{"example.com", "MX", ErrNoData}, // cached
+		{"nx.example.com", "A", ErrNXDomain},
+		{"dangling.example.com", "A", ErrNoData},
+		{"lame.example.com", "A", nil},
+		{"example.com", "", nil},
+	}
+	for _, tt := range tests {
+		rrs, err := r.ResolveErr(tt.qname, tt.qtype)
+		st.Expect(t, err, tt.err)
+		if err != nil {
+			st.Expect(t, len(rrs), 0)
+		}
+	}
+	_, detail, err := r.ResolveContextDetail(context.Background(), "example.com", "MX")
+	st.Expect(t, err, ErrNoData)
+	st.Expect(t, detail.Reason, ReasonNoData)
+
+	r, _ = newTestResolver(t, records, WithNoDataError(), WithIncludeNegativeSOA())
+	rrs, err := r.ResolveErr("example.com", "MX")
+	st.Expect(t, err, ErrNoData)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "SOA" }), 1)
+
+	r, _ = newTestResolver(t, records)
+	_, err = r.ResolveErr("example.com", "MX")
+	st.Expect(t, err, nil)
+}
+
+func TestWithStrictCNAME(t *testing.T) {
+	records := append([]string{
+		"broken.example.com. 300 IN CNAME example.com.",
+		"broken.example.com. 300 IN A 192.0.2.9",
+	}, testZoneRecords...)
+	r, _ := newTestResolver(t, records)
+	rrs, err := r.ResolveErr("broken.example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Name == "broken.example.com." && rr.Type == "A" }), 1)
+
+	r, _ = newTestResolver(t, records, WithStrictCNAME())
+	for i := 0; i < 2; i++ { // uncached and cached
+		rrs, err = r.ResolveErr("broken.example.com", "A")
+		st.Expect(t, err, ErrCNAMEAndOtherData)
+		st.Expect(t, rrs, RRs(nil))
+	}
+	rrs, err = r.ResolveErr("www.example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Name == "example.com." && rr.Type == "A" }) >= 1, true)
+}
+
+func TestCNAMEChainTTL(t *testing.T) {
+	records := append([]string{
+		"short.example.com. 60 IN CNAME long.example.com.",
+		"long.example.com. 3600 IN A 203.0.113.2",
+	}, testZoneRecords...)
+	r, _ := newTestResolver(t, records, WithAnswerOnly(), WithExpiry())
+	rrs, err := r.ResolveErr("short.example.com", "A")
+	st.Expect(t, err, nil)
+	st.Assert(t, len(rrs), 2)
+	st.Expect(t, rrs[0].Type, "CNAME")
+	st.Expect(t, rrs[1].Type, "A")
+	st.Expect(t, rrs[0].TTL, 60*time.Second)
+	st.Expect(t, rrs[1].TTL, 3600*time.Second)
+	st.Expect(t, rrs[1].Expiry, rrs[0].Expiry)
+	st.Expect(t, rrs[1].Expiry.Before(time.Now().Add(120*time.Second)), true)
+
+	rrs, err = r.ResolveErr("long.example.com", "A")
+	st.Expect(t, err, nil)
+	st.Assert(t, len(rrs), 1)
+	st.Expect(t, rrs[0].TTL, 3600*time.Second)
+
+	// Once the CNAME expires, its target is not served from its cache entry
+	later := time.Now().Add(120 * time.Second)
+	st.Expect(t, len(r.cache.getAt("short.example.com.", later)), 0)
+	st.Expect(t, len(r.cache.getAt("long.example.com.", later)), 1)
+}
+
+func TestHasCNAMEAndOtherData(t *testing.T) {
+	st.Expect(t, hasCNAMEAndOtherData(nil), false)
+	st.Expect(t, hasCNAMEAndOtherData(RRs{{Name: "a.", Type: "CNAME", Value: "b."}, {Name: "b.", Type: "A", Value: "192.0.2.1"}}), false)
+	st.Expect(t, hasCNAMEAndOtherData(RRs{{Name: "a.", Type: "CNAME", Value: "b."}, {Name: "a.", Type: "RRSIG", Value: "CNAME"}}), false)
+	st.Expect(t, hasCNAMEAndOtherData(RRs{{Name: "a.", Type: "CNAME", Value: "b."}, {Name: "a.", Type: "A", Value: "192.0.2.1"}}), true)
+}
+
+func TestWithRootServers(t *testing.T) {
+	r, d := newTestResolver(t, testZoneRecords, WithRootServers([]string{"192.0.2.250", "2001:db8::250", "invalid"}))
+	rrs, err := r.ResolveErr(".", "NS")
+	st.Expect(t, err, nil)
+	st.Expect(t, len(rrs), 2)
+	rrs, err = r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" }), 1)
+	st.Expect(t, d.Dials()[0], "udp 192.0.2.250:53")
+	st.Expect(t, NewResolver().root, rootCache)
+}
+
+func TestWithRootHints(t *testing.T) {
+	hints := ". 3600000 NS a.root.test.\na.root.test. 3600000 A 192.0.2.251\n"
+	r, d := newTestResolver(t, testZoneRecords, WithRootHints(strings.NewReader(hints)))
+	rrs, err := r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" }), 1)
+	st.Expect(t, d.Dials()[0], "udp 192.0.2.251:53")
+
+	r = NewResolver(WithDialer(failDialer{}), WithRootHints(strings.NewReader("; no records\n")))
+	_, err = r.ResolveErr("example.com", "A")
+	st.Reject(t, err, nil)
+	st.Expect(t, strings.HasPrefix(err.Error(), "root hints:"), true)
+
+	// Cache hits fail the same way
+	r.cache.add("example.com.", RR{Name: "example.com.", Type: "A", Value: "203.0.113.1"})
+	_, err = r.ResolveErr("example.com", "A")
+	st.Reject(t, err, nil)
+	st.Expect(t, strings.HasPrefix(err.Error(), "root hints:"), true)
+}
+
+func TestWithAllowlist(t *testing.T) {
+	r := NewResolver(WithAllowlist([]string{"Example.com", "example.net."}))
+	st.Expect(t, r.allowlist, []string{"example.com.", "example.net."})
+	r.cache.add("example.com.", RR{Name: "example.com.", Type: "A", Value: "203.0.113.1"})
+	r.cache.add("www.example.net.", RR{Name: "www.example.net.", Type: "A", Value: "203.0.113.2"})
+
+	rrs, err := r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, len(rrs), 1)
+	rrs, err = r.ResolveContext(context.Background(), "www.example.net", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, len(rrs), 1)
+
+	_, err = r.ResolveErr("example.org", "A")
+	st.Expect(t, err, ErrNotAllowed)
+	_, err = r.ResolveErr("notexample.com", "A")
+	st.Expect(t, err, ErrNotAllowed)
+	_, err = r.ResolveContext(context.Background(), "com", "NS")
+	st.Expect(t, err, ErrNotAllowed)
+}
+
 func TestNewExpiring(t *testing.T) {
 	r := NewExpiring(42)
 	st.Expect(t, r.cache.capacity, 42)
@@ -73,12 +1168,75 @@ func TestTimeoutExpiration(t *testing.T) {
 	r := NewResolver(WithTimeout(10 * time.Millisecond))
 	_, err := r.ResolveErr("1.com", "")
 	st.Expect(t, err, ErrTimeout)
+	st.Expect(t, errors.Is(err, context.DeadlineExceeded), true)
 }
 
 func TestDeadlineExceeded(t *testing.T) {
 	r := NewResolver(WithTimeout(0))
 	_, err := r.ResolveErr("1.com", "")
-	st.Expect(t, err, context.DeadlineExceeded)
+	st.Expect(t, err, ErrTimeout)
+	st.Expect(t, errors.Is(err, context.DeadlineExceeded), true)
+}
+
+func TestContextDeadlineExceeded(t *testing.T) {
+	r := NewResolver()
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err := r.ResolveContext(ctx, "1.com", "")
+	st.Expect(t, err, ErrTimeout)
+	st.Expect(t, errors.Is(err, context.DeadlineExceeded), true)
+}
+
+func TestErrTimeout(t *testing.T) {
+	var te interface{ Timeout() bool }
+	st.Expect(t, errors.As(ErrTimeout, &te), true)
+	st.Expect(t, te.Timeout(), true)
+	st.Expect(t, ErrTimeout.Error(), "timeout expired")
+	nerr, ok := ErrTimeout.(net.Error)
+	st.Expect(t, ok, true)
+	st.Expect(t, nerr.Timeout(), true)
+}
+
+func TestTemporary(t *testing.T) {
+	type netError interface {
+		Timeout() bool
+		Temporary() bool
+	}
+	tests := []struct {
+		err       error
+		temporary bool
+		timeout   bool
+	}{
+		{NXDOMAIN, false, false},
+		{ErrInvalidName, false, false},
+		{ErrNotAllowed, false, false},
+		{ErrDelegationLoop, false, false},
+		{ErrMaxRecursion, false, false},
+		{&MaxRecursionError{Qname: "example.com.", Qtype: "A"}, false, false},
+		{ErrTimeout, true, true},
+		{ErrNoResponse, true, false},
+		{ErrMaxIPs, true, false},
+		{rcodeError(dns.RcodeServerFailure), true, false},
+		{rcodeError(dns.RcodeRefused), false, false},
+	}
+	for _, tt := range tests {
+		var ne netError
+		st.Assert(t, errors.As(tt.err, &ne), true)
+		st.Expect(t, ne.Temporary(), tt.temporary)
+		st.Expect(t, ne.Timeout(), tt.timeout)
+	}
+
+	r, _ := newTestResolver(t, testZoneRecords)
+	for _, qname := range []string{"nx.example.com", ""} {
+		_, err := r.ResolveErr(qname, "A")
+		var ne netError
+		st.Assert(t, errors.As(err, &ne), true)
+		st.Expect(t, ne.Temporary(), false)
+	}
+	_, err := NewResolver(WithDialer(failDialer{}), WithRootServers([]string{"192.0.2.250"})).ResolveErr("example.com", "A")
+	var ne netError
+	st.Assert(t, errors.As(err, &ne), true)
+	st.Expect(t, ne.Temporary(), true)
 }
 
 func TestResolveCtx(t *testing.T) {
@@ -101,6 +1259,180 @@ func TestResolveContext(t *testing.T) {
 	st.Expect(t, err, context.Canceled)
 }
 
+func TestResolveErrTTL(t *testing.T) {
+	r, _ := newTestResolver(t, testZoneRecords, WithExpiry(), WithAnswerOnly())
+	for i := 0; i < 2; i++ { // uncached and cached
+		rrs, err := r.ResolveErr("example.com", "A")
+		st.Expect(t, err, nil)
+		st.Assert(t, len(rrs), 1)
+		st.Expect(t, rrs[0].TTL, 300*time.Second)
+		st.Expect(t, rrs[0].Expiry.After(time.Now().Add(299*time.Second)), true)
+		st.Expect(t, rrs[0].String(), "example.com.\t       300\tIN\tA\t203.0.113.1")
+	}
+
+	// Root hints keep their TTL, but never expire
+	for _, rr := range RootHints() {
+		st.Expect(t, rr.TTL, 3600000*time.Second)
+		st.Expect(t, rr.Expiry.IsZero(), true)
+	}
+}
+
+func TestResolveContextDone(t *testing.T) {
+	r, d := newTestResolver(t, testZoneRecords)
+	_, err := r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	n := len(d.Dials())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, qname := range []string{"example.com", "www.example.com"} { // cached and uncached
+		rrs, err := r.ResolveContext(ctx, qname, "A")
+		st.Expect(t, err, context.Canceled)
+		st.Expect(t, len(rrs), 0)
+	}
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	_, err = r.ResolveContext(ctx, "example.com", "A")
+	st.Expect(t, err, ErrTimeout)
+	st.Expect(t, len(d.Dials()), n)
+}
+
+func TestResolveZone(t *testing.T) {
+	r, _ := newTestResolver(t, testZoneRecords, WithAnswerOnly())
+	var b strings.Builder
+	err := r.ResolveZone(context.Background(), &b, "example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, b.String(), "example.com. 300 IN A 203.0.113.1\n")
+
+	b.Reset()
+	err = r.ResolveZone(context.Background(), &b, "nx.example.com", "A")
+	st.Expect(t, err, NXDOMAIN)
+	st.Expect(t, b.Len(), 0)
+
+	r, _ = newTestResolver(t, testZoneRecords, WithAnswerOnly(), WithExpiry())
+	_, err = r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	expireCache(r, time.Now().Add(100*time.Second))
+	b.Reset()
+	err = r.ResolveZone(context.Background(), &b, "example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, b.String(), "example.com. 100 IN A 203.0.113.1\n")
+}
+
+func TestResolveRaw(t *testing.T) {
+	records := append(slices.Clone(testZoneRecords),
+		"example.com. 300 IN MX 10 mx1.example.com.",
+		"example.com. 300 IN MX 20 mx2.example.com.",
+	)
+	r, _ := newTestResolver(t, records, WithAnswerOnly(), WithRawRecords())
+	drrs, err := r.ResolveRaw(context.Background(), "example.com", "MX")
+	st.Expect(t, err, nil)
+	prefs := map[string]uint16{}
+	for _, drr := range drrs {
+		mx, ok := drr.(*dns.MX)
+		st.Assert(t, ok, true)
+		prefs[mx.Mx] = mx.Preference
+	}
+	st.Expect(t, prefs, map[string]uint16{"mx1.example.com.": 10, "mx2.example.com.": 20})
+
+	// Cached records are returned as copies
+	drrs[0].(*dns.MX).Preference = 99
+	drrs, err = r.ResolveRaw(context.Background(), "example.com", "MX")
+	st.Expect(t, err, nil)
+	for _, drr := range drrs {
+		st.Reject(t, drr.(*dns.MX).Preference, uint16(99))
+	}
+
+	drrs, err = r.ResolveRaw(context.Background(), "example.com", "SOA")
+	st.Expect(t, err, nil)
+	st.Assert(t, len(drrs), 1)
+	soa, ok := drrs[0].(*dns.SOA)
+	st.Assert(t, ok, true)
+	st.Expect(t, soa.Serial, uint32(1))
+	st.Expect(t, soa.Minttl, uint32(300))
+
+	_, err = r.ResolveRaw(context.Background(), "nx.example.com", "MX")
+	st.Expect(t, err, NXDOMAIN)
+
+	// Without WithRawRecords, records are converted from their RR
+	r, _ = newTestResolver(t, records, WithAnswerOnly())
+	drrs, err = r.ResolveRaw(context.Background(), "example.com", "MX")
+	st.Expect(t, err, nil)
+	st.Expect(t, len(drrs), 2)
+	for _, drr := range drrs {
+		mx, ok := drr.(*dns.MX)
+		st.Assert(t, ok, true)
+		st.Expect(t, mx.Preference, prefs[mx.Mx])
+	}
+	st.Expect(t, len(r.cache.getRaw(r.cache.get("example.com.")[0])), 0)
+}
+
+func TestResolveTXTStrings(t *testing.T) {
+	// Records with the same value but different strings are distinct
+	records := append(slices.Clone(testZoneRecords),
+		`example.com. 300 IN TXT "ab" "c"`,
+		`example.com. 300 IN TXT "a" "bc"`,
+	)
+	for _, opts := range [][]Option{nil, {WithRawRecords()}} {
+		r, _ := newTestResolver(t, records, append(opts, WithAnswerOnly())...)
+		rrs, err := r.ResolveErr("example.com", "TXT")
+		st.Expect(t, err, nil)
+		st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "TXT" && rr.Value == "abc" }), 2)
+		drrs, err := r.ResolveRaw(context.Background(), "example.com", "TXT")
+		st.Expect(t, err, nil)
+		st.Expect(t, len(drrs), 2)
+	}
+
+	r, _ := newTestResolver(t, records, WithAnswerOnly(), WithRawRecords())
+	drrs, err := r.ResolveRaw(context.Background(), "example.com", "TXT")
+	st.Expect(t, err, nil)
+	var txts []string
+	for _, drr := range drrs {
+		txts = append(txts, strings.Join(drr.(*dns.TXT).Txt, "|"))
+	}
+	slices.Sort(txts)
+	st.Expect(t, txts, []string{"ab|c", "a|bc"})
+}
+
+func TestResolveSRV(t *testing.T) {
+	records := append(slices.Clone(testZoneRecords),
+		"_xmpp-server._tcp.example.com. 300 IN SRV 5 0 5269 xmpp.example.com.",
+		"_xmpp-server._tcp.example.com. 300 IN SRV 10 0 5269 xmpp2.example.com.",
+	)
+	r, _ := newTestResolver(t, records, WithAnswerOnly())
+	rrs, err := r.ResolveErr("_xmpp-server._tcp.example.com", "SRV")
+	st.Expect(t, err, nil)
+	var srvs []string
+	for _, rr := range rrs {
+		priority, weight, port, target, ok := rr.SRV()
+		st.Assert(t, ok, true)
+		srvs = append(srvs, fmt.Sprintf("%d %d %d %s", priority, weight, port, target))
+	}
+	slices.Sort(srvs)
+	st.Expect(t, srvs, []string{
+		"10 0 5269 xmpp2.example.com.",
+		"5 0 5269 xmpp.example.com.",
+	})
+}
+
+func TestResolveOpts(t *testing.T) {
+	r, _ := newTestResolver(t, testZoneRecords)
+	rrs, err := r.ResolveOpts(context.Background(), "example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "NS" }), 2)
+
+	rrs, err = r.ResolveOpts(context.Background(), "example.com", "A", CallAnswerOnly(true))
+	st.Expect(t, err, nil)
+	st.Expect(t, len(rrs), 1)
+	st.Expect(t, rrs[0].Type, "A")
+	st.Expect(t, r.answerOnly, false)
+
+	_, err = r.ResolveOpts(context.Background(), "nx.example.com", "A", CallTimeout(0), CallTCPRetry(true))
+	st.Expect(t, err, ErrTimeout)
+	st.Expect(t, r.timeout, Timeout)
+	st.Expect(t, r.tcpRetry, false)
+}
+
 func TestResolverCache(t *testing.T) {
 	r := NewResolver()
 	r.cache.capacity = 10
@@ -117,11 +1449,678 @@ func TestResolverCache(t *testing.T) {
 	st.Expect(t, err, NXDOMAIN)
 	st.Expect(t, rrs, (RRs)(nil))
 	r.cache.m.Lock()
-	st.Expect(t, r.cache.entries["a.com"], entry(nil))
+	st.Expect(t, r.cache.entries["a.com"], (*entry)(nil))
 	st.Expect(t, len(r.cache.entries), 10)
 	r.cache.m.Unlock()
 }
 
+func TestPinZone(t *testing.T) {
+	r := NewResolver(WithCache(10))
+	r.cache.add("example.", RR{Name: "example.", Type: "NS", Value: "ns1.example."})
+	r.cache.add("ns1.example.", RR{Name: "ns1.example.", Type: "A", Value: "203.0.113.1"})
+	err := r.PinZone(context.Background(), "EXAMPLE")
+	st.Expect(t, err, nil)
+	for i := 0; i < 100; i++ {
+		k := fmt.Sprintf("%d.com.", i)
+		r.cache.add(k, RR{Name: k, Type: "A", Value: "203.0.113.2"})
+	}
+	st.Expect(t, len(r.cache.get("example.")), 1)
+	st.Expect(t, len(r.cache.get("ns1.example.")), 1)
+	r.UnpinZone("example")
+	r.cache.m.Lock()
+	st.Expect(t, len(r.cache.pinned), 0)
+	r.cache.m.Unlock()
+
+	err = r.PinZone(context.Background(), "invalid..example")
+	st.Expect(t, err, ErrInvalidName)
+}
+
+func TestPinZoneIPv6(t *testing.T) {
+	records := append(slices.Clone(testZoneRecords),
+		"ns1.example.com. 300 IN AAAA 2001:db8::53",
+		"ns2.example.com. 300 IN AAAA 2001:db8::54",
+	)
+	r, _ := newTestResolver(t, records, WithCache(10), WithAddressFamily(DualStack))
+	// Only the IPv4 addresses of the name servers are cached
+	for _, host := range []string{"ns1.example.com.", "ns2.example.com."} {
+		r.cache.add("example.com.", RR{Name: "example.com.", Type: "NS", Value: host})
+	}
+	r.cache.add("ns1.example.com.", RR{Name: "ns1.example.com.", Type: "A", Value: "192.0.2.53"})
+	r.cache.add("ns2.example.com.", RR{Name: "ns2.example.com.", Type: "A", Value: "192.0.2.54"})
+	err := r.PinZone(context.Background(), "example.com")
+	st.Expect(t, err, nil)
+	for i := 0; i < 100; i++ {
+		k := fmt.Sprintf("%d.com.", i)
+		r.cache.add(k, RR{Name: k, Type: "A", Value: "203.0.113.2"})
+	}
+	for _, host := range []string{"ns1.example.com", "ns2.example.com"} {
+		rrs, ok := r.ResolveCached(host, "AAAA")
+		st.Expect(t, ok, true)
+		st.Expect(t, len(rrs), 1)
+	}
+}
+
+func TestPrime(t *testing.T) {
+	r := NewResolver(WithCache(10), WithExpiry())
+	r.Prime(RRs{
+		{Name: "Primed.example", Type: "A", Value: "192.0.2.1", Expiry: time.Now().Add(-time.Hour)},
+		{Name: "primed.example.", Type: "A", Value: "192.0.2.2"},
+		{Name: "other.example.", Type: "TXT", Value: "hello"},
+	})
+	for i := 0; i < 100; i++ {
+		k := fmt.Sprintf("%d.com.", i)
+		r.cache.add(k, RR{Name: k, Type: "A", Value: "203.0.113.2"})
+	}
+	st.Expect(t, r.Stats().Evictions > 0, true)
+
+	rrs, ok := r.ResolveCached("primed.example", "A")
+	st.Expect(t, ok, true)
+	st.Expect(t, len(rrs), 2)
+	st.Expect(t, rrs[0].Authoritative, true)
+	rrs, ok = r.ResolveCached("other.example", "TXT")
+	st.Expect(t, ok, true)
+	st.Expect(t, len(rrs), 1)
+	cached := 0
+	for i := 0; i < 100; i++ {
+		if _, ok := r.ResolveCached(fmt.Sprintf("%d.com", i), "A"); ok {
+			cached++
+		}
+	}
+	st.Expect(t, cached, 8) // the rest were evicted
+}
+
+var testQueryVariants = []string{"example.com", "example.com.", "Example.COM", "EXAMPLE.COM."}
+
+func TestNormalization(t *testing.T) {
+	r, d := newTestResolver(t, testZoneRecords)
+	var n int
+	for i, qname := range testQueryVariants {
+		rrs, err := r.ResolveErr(qname, "A")
+		st.Expect(t, err, nil)
+		st.Expect(t, count(rrs, func(rr RR) bool { return rr.Name == "example.com." && rr.Type == "A" }), 1)
+		for _, rr := range rrs {
+			st.Expect(t, rr.Name, toLowerFQDN(rr.Name))
+		}
+		rrs, err = r.ResolveContext(context.Background(), qname, "A")
+		st.Expect(t, err, nil)
+		st.Expect(t, count(rrs, func(rr RR) bool { return rr.Name == "example.com." && rr.Type == "A" }), 1)
+		if i == 0 {
+			n = len(d.Dials())
+		}
+	}
+	st.Expect(t, len(d.Dials()), n) // variants share cache entries
+}
+
+func TestWithReturnAsQueried(t *testing.T) {
+	r, _ := newTestResolver(t, testZoneRecords, WithReturnAsQueried())
+	for _, qname := range testQueryVariants {
+		rrs, err := r.ResolveErr(qname, "A")
+		st.Expect(t, err, nil)
+		st.Expect(t, count(rrs, func(rr RR) bool { return rr.Name == qname && rr.Type == "A" }), 1)
+		rrs, _, err = r.ResolveContextDetail(context.Background(), qname, "A")
+		st.Expect(t, err, nil)
+		st.Expect(t, count(rrs, func(rr RR) bool { return rr.Name == qname && rr.Type == "A" }), 1)
+	}
+	// Other names are normalized
+	rrs, err := r.ResolveErr("WWW.example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Name == "WWW.example.com" && rr.Type == "CNAME" }) >= 1, true)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Name == "example.com." && rr.Type == "A" }) >= 1, true)
+	// The cache is unaffected
+	st.Expect(t, count(r.cache.get("example.com."), func(rr RR) bool { return rr.Name == "example.com." }) > 0, true)
+}
+
+func TestRange(t *testing.T) {
+	r := NewResolver()
+	r.cache.add("example.com.", RR{Name: "example.com.", Type: "A", Value: "203.0.113.1"})
+	r.cache.add("example.com.", RR{Name: "example.com.", Type: "TXT", Value: "hello"})
+	r.cache.addNX("nx.example.com.", time.Time{})
+	r.cache.addNoData("www.example.com.", "MX", time.Time{})
+	got := make(map[string]RRs)
+	r.Range(func(qname string, rrs RRs) bool {
+		got[qname] = rrs
+		return true
+	})
+	st.Expect(t, len(got), 2)
+	st.Expect(t, len(got["example.com."]), 2)
+	st.Expect(t, got["nx.example.com."], RRs{})
+
+	n := 0
+	r.Range(func(qname string, rrs RRs) bool {
+		n++
+		return false
+	})
+	st.Expect(t, n, 1)
+}
+
+func TestWithNameRewrite(t *testing.T) {
+	rewrite := func(qname string) string {
+		if name, ok := strings.CutSuffix(qname, ".internal."); ok {
+			return name + ".example.com."
+		}
+		if qname == "bad.example." {
+			return "bad..example."
+		}
+		return qname
+	}
+	r, d := newTestResolver(t, testZoneRecords, WithNameRewrite(rewrite))
+	rrs, err := r.ResolveErr("WWW.internal", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Name == "www.example.com." && rr.Type == "CNAME" }) >= 1, true)
+	st.Expect(t, len(r.cache.get("www.example.com.")) > 0, true)
+	st.Expect(t, r.cache.get("www.internal."), RRs(nil))
+
+	n := len(d.Dials())
+	rrs, err = r.ResolveErr("www.example.com", "A") // cached under the rewritten name
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" }) >= 1, true)
+	st.Expect(t, len(d.Dials()), n)
+
+	_, err = r.ResolveErr("bad.example", "A")
+	st.Expect(t, err, ErrInvalidName)
+}
+
+func TestInvalidName(t *testing.T) {
+	r := NewResolver(WithDialer(failDialer{}))
+	ctx := context.Background()
+	for _, qname := range []string{"", strings.Repeat("a.", 127) + "a", "exa mple.com", "a..b.com", "bad\\"} {
+		_, err := r.ResolveErr(qname, "A")
+		st.Expect(t, err, ErrInvalidName)
+		_, err = r.ResolveContext(ctx, qname, "A")
+		st.Expect(t, err, ErrInvalidName)
+		_, err = r.ResolveAll(ctx, qname)
+		st.Expect(t, err, ErrInvalidName)
+		_, _, err = r.ResolveContextDetail(ctx, qname, "A")
+		st.Expect(t, err, ErrInvalidName)
+		st.Expect(t, len(r.Resolve(qname, "A")), 0)
+	}
+}
+
+func TestSetCacheCapacity(t *testing.T) {
+	r, _ := newTestResolver(t, testZoneRecords, WithCache(100))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				r.ResolveErr("www.example.com", "A") // may fail while the cache shrinks
+			}
+		}()
+	}
+	for _, n := range []int{1, 50, 2, 100} {
+		r.SetCacheCapacity(n)
+	}
+	wg.Wait()
+	r.SetCacheCapacity(1)
+	n := 0
+	r.Range(func(string, RRs) bool { n++; return true })
+	st.Expect(t, n <= 1, true)
+}
+
+func TestResolveCached(t *testing.T) {
+	r := NewResolver(WithDialer(failDialer{}), WithExpiry())
+	r.cache.add("example.com.", RR{Name: "example.com.", Type: "A", Value: "203.0.113.1", Expiry: time.Now().Add(time.Hour)})
+	r.cache.add("expired.example.com.", RR{Name: "expired.example.com.", Type: "A", Value: "203.0.113.2", Expiry: time.Now().Add(-time.Hour)})
+	r.cache.addNX("nx.example.com.", time.Time{})
+
+	rrs, ok := r.ResolveCached("Example.com", "A")
+	st.Expect(t, ok, true)
+	st.Expect(t, len(rrs), 1)
+
+	rrs, ok = r.ResolveCached("example.com", "MX")
+	st.Expect(t, ok, false)
+	st.Expect(t, len(rrs), 0)
+
+	rrs, ok = r.ResolveCached("nx.example.com", "A")
+	st.Expect(t, ok, true)
+	st.Expect(t, len(rrs), 0)
+
+	_, ok = r.ResolveCached("expired.example.com", "A")
+	st.Expect(t, ok, false)
+
+	_, ok = r.ResolveCached("uncached.example.com", "A")
+	st.Expect(t, ok, false)
+
+	rrs, ok = r.ResolveCached("a.root-servers.net", "A") // from the root hints
+	st.Expect(t, ok, true)
+	st.Expect(t, len(rrs), 1)
+
+	_, ok = r.ResolveCached("", "A")
+	st.Expect(t, ok, false)
+}
+
+func TestWithEvictionCallback(t *testing.T) {
+	var n int
+	var r *Resolver
+	r = NewResolver(WithCache(1), WithEvictionCallback(func(qname string, reason EvictReason) {
+		n++
+		st.Expect(t, reason, EvictCapacity)
+		r.cache.get(qname) // the cache is unlocked
+	}))
+	r.cache.add("a.", RR{Name: "a.", Type: "A", Value: "1.2.3.4"})
+	r.cache.add("b.", RR{Name: "b.", Type: "A", Value: "1.2.3.4"})
+	st.Expect(t, n, 1)
+}
+
+func TestSVCBAlias(t *testing.T) {
+	records := append([]string{
+		"example.com. 300 IN HTTPS 0 svc.example.com.",
+		"svc.example.com. 300 IN HTTPS 1 . alpn=h3",
+		"unavailable.example.com. 300 IN HTTPS 0 .",
+	}, testZoneRecords...)
+	r, _ := newTestResolver(t, records)
+	rrs, err := r.ResolveErr("example.com", "HTTPS")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Name == "example.com." && rr.Value == "0\tsvc.example.com." }), 1)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Name == "svc.example.com." && rr.Value == "1\t.\talpn=\"h3\"" }) >= 1, true)
+
+	rrs, err = r.ResolveErr("unavailable.example.com", "HTTPS")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "HTTPS" }), 1)
+
+	target, ok := svcbAlias(RR{Type: "SVCB", Value: "0\tTarget.example.com."}, "SVCB")
+	st.Expect(t, ok, true)
+	st.Expect(t, target, "target.example.com.")
+	_, ok = svcbAlias(RR{Type: "SVCB", Value: "1\ttarget.example.com."}, "SVCB")
+	st.Expect(t, ok, false)
+	_, ok = svcbAlias(RR{Type: "HTTPS", Value: "0\ttarget.example.com."}, "SVCB")
+	st.Expect(t, ok, false)
+}
+
+func TestSVCBAliasLoop(t *testing.T) {
+	records := append([]string{
+		"a.example.com. 300 IN SVCB 0 b.example.com.",
+		"b.example.com. 300 IN SVCB 0 a.example.com.",
+	}, testZoneRecords...)
+	r, _ := newTestResolver(t, records)
+	rrs, err := r.ResolveErr("a.example.com", "SVCB")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "SVCB" }) >= 2, true)
+}
+
+func TestWithResolutionRetry(t *testing.T) {
+	zone := newTestZone(t, testZoneRecords...)
+	for _, retries := range []int{0, 1} {
+		// Fail the first two queries, to the root name server for com. NS,
+		// so the first attempt fails with ErrNoResponse
+		failures := make(chan struct{}, 2)
+		failures <- struct{}{}
+		failures <- struct{}{}
+		s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+			select {
+			case <-failures:
+				m := new(dns.Msg)
+				m.SetRcode(req, dns.RcodeServerFailure)
+				w.WriteMsg(m)
+			default:
+				zone.ServeDNS(w, req)
+			}
+		}))
+		r := NewResolver(WithDialer(s.Dialer()), WithRootServers([]string{"192.0.2.250"}), WithResolutionRetry(retries))
+		rrs, err := r.ResolveErr("example.com", "A")
+		if retries == 0 {
+			st.Expect(t, err, ErrNoResponse)
+			continue
+		}
+		st.Expect(t, err, nil)
+		st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" }), 1)
+	}
+
+	// Permanent errors aren’t retried
+	var mu sync.Mutex
+	n := 0
+	s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		if q := req.Question[0]; q.Name == "nx.example.com." && q.Qtype == dns.TypeA {
+			mu.Lock()
+			n++
+			mu.Unlock()
+		}
+		zone.ServeDNS(w, req)
+	}))
+	r := NewResolver(WithDialer(s.Dialer()), WithResolutionRetry(3))
+	_, err := r.ResolveErr("nx.example.com", "A")
+	st.Expect(t, err, NXDOMAIN)
+	mu.Lock()
+	st.Expect(t, n >= 1 && n <= 2, true) // at most one query to each name server for example.com
+	mu.Unlock()
+}
+
+func TestWithStrictResolve(t *testing.T) {
+	// A name server that returns empty responses without an SOA record
+	zone := newTestZone(t, append([]string{"empty.example.com. 300 IN A 203.0.113.4"}, testZoneRecords...)...)
+	s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := zone.reply(req)
+		if req.Question[0].Name == "empty.example.com." {
+			m.Ns = nil
+		}
+		w.WriteMsg(m)
+	}))
+	for _, strict := range []bool{false, true} {
+		options := []Option{WithDialer(s.Dialer())}
+		if strict {
+			options = append(options, WithStrictResolve())
+		}
+		r := NewResolver(options...)
+		rrs := r.Resolve("empty.example.com", "MX")
+		st.Expect(t, rrs == nil, !strict)
+		st.Expect(t, len(rrs), 0)
+		st.Expect(t, r.Resolve("nx.example.com", "A"), RRs{})
+	}
+
+	// Failures return nil
+	r := NewResolver(WithDialer(failDialer{}), WithRootServers([]string{"192.0.2.250"}), WithStrictResolve())
+	st.Expect(t, r.Resolve("example.com", "A"), RRs(nil))
+}
+
+func TestWithRandSource(t *testing.T) {
+	records := append([]string(nil), testZoneRecords...)
+	for i := 1; i <= 20; i++ {
+		records = append(records, fmt.Sprintf("many.example.com. 300 IN A 203.0.113.%d", i))
+	}
+	resolve := func(seed int64) []string {
+		r, _ := newTestResolver(t, records, WithRandSource(rand.NewSource(seed)))
+		rrs, err := r.ResolveErr("many.example.com", "A")
+		st.Expect(t, err, nil)
+		rrs, ok := r.ResolveCached("many.example.com", "A")
+		st.Expect(t, ok, true)
+		var values []string
+		for _, rr := range rrs {
+			values = append(values, rr.Value)
+		}
+		return values
+	}
+	a := resolve(1)
+	st.Expect(t, len(a), 20)
+	st.Expect(t, resolve(1), a)
+	st.Expect(t, slices.Equal(resolve(2), a), false)
+
+	// Seeded from crypto/rand by default
+	r1, r2 := NewResolver(), NewResolver()
+	st.Reject(t, r1.rand, (*lockedRand)(nil))
+	st.Expect(t, r1.rand.uint64() == r2.rand.uint64(), false)
+}
+
+func TestWithRoundRobin(t *testing.T) {
+	records := append([]string{
+		"rr.example.com. 300 IN A 203.0.113.1",
+		"rr.example.com. 300 IN A 203.0.113.2",
+		"rr.example.com. 300 IN A 203.0.113.3",
+	}, testZoneRecords...)
+	// Lookups within a resolution, e.g. for WithStrictCNAME, don’t rotate
+	for _, opts := range [][]Option{nil, {WithStrictCNAME(), WithIncludeNegativeSOA()}} {
+		r, _ := newTestResolver(t, records, append(opts, WithRoundRobin(), WithAnswerOnly())...)
+		var firsts []string
+		for i := 0; i < 4; i++ {
+			rrs, err := r.ResolveErr("rr.example.com", "A")
+			st.Expect(t, err, nil)
+			st.Assert(t, len(rrs), 3)
+			firsts = append(firsts, rrs[0].Value)
+		}
+		st.Expect(t, firsts, []string{"203.0.113.1", "203.0.113.2", "203.0.113.3", "203.0.113.1"})
+	}
+}
+
+func TestWithMaxResponseSize(t *testing.T) {
+	records := []string{
+		"com. 172800 IN NS a.gtld-servers.net.",
+		"a.gtld-servers.net. 172800 IN A 192.0.2.1",
+		"com. 900 IN SOA a.gtld-servers.net. nstld.verisign-grs.com. 1 1800 900 604800 86400",
+		"example.com. 172800 IN NS ns1.example.com.",
+		"ns1.example.com. 172800 IN A 192.0.2.53",
+		"ns1.example.com. 172800 IN A 192.0.2.54",
+		"example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300",
+		`example.com. 300 IN TXT "hello"`,
+	}
+	zone := newTestZone(t, records...)
+	oversized := make(chan struct{}, 1)
+	var mu sync.Mutex
+	n := 0
+	s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := zone.reply(req)
+		if q := req.Question[0]; q.Name == "example.com." && q.Qtype == dns.TypeTXT {
+			mu.Lock()
+			n++
+			mu.Unlock()
+			select {
+			case <-oversized: // the first response is too large
+				m.Answer = append(m.Answer, &dns.TXT{
+					Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 300},
+					Txt: []string{strings.Repeat("x", 250), strings.Repeat("y", 250)},
+				})
+			default:
+			}
+		}
+		w.WriteMsg(m)
+	}))
+	for _, opts := range [][]Option{nil, {WithTCPOnly()}} {
+		oversized <- struct{}{}
+		mu.Lock()
+		n = 0
+		mu.Unlock()
+		r := NewResolver(append(opts, WithDialer(s.Dialer()), WithMaxResponseSize(512))...)
+		rrs, err := r.ResolveErr("example.com", "TXT")
+		st.Expect(t, err, nil)
+		st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "TXT" }), 1)
+		mu.Lock()
+		st.Expect(t, n, 2) // the second address of ns1.example.com
+		mu.Unlock()
+	}
+	st.Expect(t, temporary(ErrResponseTooLarge), true)
+	st.Expect(t, NewResolver().maxResponse, MaxResponseSize)
+}
+
+func TestWithMaxResponseSizeCompressed(t *testing.T) {
+	// A response compressed within the limit, but larger uncompressed
+	name := "a-rather-long-label-for-compression.example.com."
+	records := slices.Clone(testZoneRecords)
+	for i := 1; i <= 40; i++ {
+		records = append(records, fmt.Sprintf("%s 300 IN A 203.0.113.%d", name, i))
+	}
+	zone := newTestZone(t, records...)
+	var uncompressed, compressed int
+	var mu sync.Mutex
+	s := newTestServer(t, dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		m := zone.reply(req)
+		if req.Question[0].Name == name {
+			mu.Lock()
+			uncompressed, compressed = m.Len(), wireLen(m)
+			mu.Unlock()
+		}
+		m.Compress = true
+		w.WriteMsg(m)
+	}))
+	for _, opts := range [][]Option{nil, {WithTCPOnly()}} {
+		r := NewResolver(append(opts, WithDialer(s.Dialer()), WithMaxResponseSize(1024), WithAnswerOnly())...)
+		rrs, err := r.ResolveErr(name, "A")
+		st.Expect(t, err, nil)
+		st.Expect(t, len(rrs), 40)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	st.Expect(t, uncompressed > 1024, true)
+	st.Expect(t, compressed <= 1024, true)
+}
+
+func TestLimitStreamConn(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	go c2.Write([]byte{0xff, 0xff}) // length prefix of 65535 bytes
+	r := NewResolver(WithMaxResponseSize(512))
+	_, err := r.limitConn(c1).Read(make([]byte, 2))
+	st.Expect(t, err, ErrResponseTooLarge)
+}
+
+func TestRootHints(t *testing.T) {
+	rrs := RootHints()
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Name == "." && rr.Type == "NS" }), 13)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Name == "a.root-servers.net." && rr.Type == "A" }), 1)
+	st.Expect(t, rrs[0].Name, ".")
+	rrs[0].Value = "modified."
+	st.Expect(t, RootHints()[0].Value != "modified.", true)
+}
+
+func TestWarmup(t *testing.T) {
+	r, _ := newTestResolver(t, testZoneRecords)
+	err := r.Warmup(context.Background(), "com")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(r.cache.get("com."), func(rr RR) bool { return rr.Type == "NS" }), 1)
+
+	err = r.Warmup(context.Background(), "com", "invalid")
+	st.Expect(t, errors.Is(err, NXDOMAIN), true)
+	st.Expect(t, err.Error(), "invalid.: NXDOMAIN")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = NewResolver().Warmup(ctx)
+	st.Expect(t, errors.Is(err, context.Canceled), true)
+}
+
+func TestRootNS(t *testing.T) {
+	r := NewResolver()
+	rrs, err := r.ResolveErr(".", "NS")
+	st.Expect(t, err, nil)
+	st.Expect(t, len(rrs), 13)
+	st.Expect(t, all(rrs, func(rr RR) bool { return rr.Type == "NS" && rr.Name == "." }), true)
+	rrs, err = r.ResolveContext(context.Background(), ".", "NS")
+	st.Expect(t, err, nil)
+	st.Expect(t, len(rrs), 13)
+}
+
+func TestRootSOA(t *testing.T) {
+	records := append([]string{
+		". 86400 IN SOA a.root-servers.net. nstld.verisign-grs.com. 1 1800 900 604800 86400",
+	}, testZoneRecords...)
+	r, _ := newTestResolver(t, records)
+	rrs, err := r.ResolveErr(".", "SOA")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "SOA" && rr.Name == "." }), 1)
+}
+
+func TestCachedNXDOMAIN(t *testing.T) {
+	for _, options := range [][]Option{nil, {WithExpiry()}} {
+		r, d := newTestResolver(t, testZoneRecords, options...)
+		_, err := r.ResolveErr("nx.example.com", "A")
+		st.Expect(t, err, NXDOMAIN)
+		n := len(d.Dials())
+		for _, qtype := range []string{"A", "MX", "NS", ""} {
+			rrs, err := r.ResolveErr("nx.example.com", qtype)
+			st.Expect(t, err, NXDOMAIN)
+			st.Expect(t, rrs, RRs(nil))
+			_, err = r.ResolveContext(context.Background(), "nx.example.com", qtype)
+			st.Expect(t, err, NXDOMAIN)
+			_, err = r.ResolveErr("www.nx.example.com", qtype)
+			st.Expect(t, err, NXDOMAIN)
+		}
+		st.Expect(t, len(d.Dials()), n)
+	}
+}
+
+func TestDelegationLoop(t *testing.T) {
+	r, _ := newTestResolver(t, []string{
+		"test. 3600 IN NS ns.test.",
+		"ns.test. 3600 IN A 192.0.2.1",
+		"a.test. 3600 IN NS ns.b.test.",
+		"b.test. 3600 IN NS ns.a.test.",
+		"www.a.test. 3600 IN A 192.0.2.2",
+	})
+	rrs, err := r.ResolveErr("www.a.test", "A")
+	st.Expect(t, err, ErrDelegationLoop)
+	st.Expect(t, len(rrs), 0)
+}
+
+func TestResolveAll(t *testing.T) {
+	records := append([]string{
+		"example.com. 300 IN MX 10 mail.example.com.",
+		"example.com. 300 IN TXT \"v=spf1 -all\"",
+		"example.com. 300 IN CAA 0 issue \"letsencrypt.org\"",
+	}, testZoneRecords...)
+	r, _ := newTestResolver(t, records, WithAnswerOnly())
+	rrs, err := r.ResolveAll(context.Background(), "example.com")
+	st.Expect(t, err, nil)
+	for _, qtype := range []string{"A", "NS", "SOA", "MX", "TXT", "CAA"} {
+		st.Expect(t, count(rrs, func(rr RR) bool { return rr.Name == "example.com." && rr.Type == qtype }) >= 1, true)
+	}
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "NS" }), 2)
+
+	rrs, err = r.ResolveAll(context.Background(), "nx.example.com")
+	st.Expect(t, err, NXDOMAIN)
+	st.Expect(t, rrs, RRs(nil))
+}
+
+func TestNonexistentNameserver(t *testing.T) {
+	records := []string{
+		"com. 172800 IN NS a.gtld-servers.net.",
+		"a.gtld-servers.net. 172800 IN A 192.0.2.1",
+		"com. 900 IN SOA a.gtld-servers.net. nstld.verisign-grs.com. 1 1800 900 604800 86400",
+		"example.com. 172800 IN NS ns1.example.com.",
+		"example.com. 172800 IN NS ns.nonexistent.com.",
+		"ns1.example.com. 172800 IN A 192.0.2.53",
+		"example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300",
+		"example.com. 300 IN A 203.0.113.1",
+	}
+	for i := 0; i < 10; i++ { // name servers are queried in random order
+		r, _ := newTestResolver(t, records)
+		rrs, err := r.ResolveErr("example.com", "A")
+		st.Expect(t, err, nil)
+		st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" }) >= 1, true)
+	}
+}
+
+func TestMaxRecursionError(t *testing.T) {
+	records := append([]string(nil), testZoneRecords...)
+	for i := 0; i < MaxRecursion+2; i++ {
+		records = append(records, fmt.Sprintf("c%d.example.com. 300 IN CNAME c%d.example.com.", i, i+1))
+	}
+	r, _ := newTestResolver(t, records)
+	_, err := r.ResolveErr("c0.example.com", "A")
+	st.Expect(t, errors.Is(err, ErrMaxRecursion), true)
+	var merr *MaxRecursionError
+	st.Assert(t, errors.As(err, &merr), true)
+	st.Expect(t, len(merr.Chain), MaxRecursion)
+	st.Expect(t, merr.Chain[0], "c0.example.com. A")
+	for _, f := range merr.Chain[1:] {
+		st.Expect(t, strings.HasPrefix(f, "c"), true) // followed the CNAME chain
+	}
+	st.Expect(t, strings.Contains(err.Error(), merr.Qname+" "+merr.Qtype), true)
+}
+
+func TestWithMinimalResponse(t *testing.T) {
+	r, _ := newTestResolver(t, testZoneRecords, WithMinimalResponse())
+	rrs, err := r.ResolveErr("www.example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "CNAME" }), 1)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" || rr.Type == "NS" }), 0)
+	_, err = r.ResolveErr("nonexistent.example.com", "A")
+	st.Expect(t, err, NXDOMAIN)
+}
+
+func TestAuthoritative(t *testing.T) {
+	r, _ := newTestResolver(t, testZoneRecords)
+	rrs, err := r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" && rr.Authoritative }), 1)
+	rrs, err = r.ResolveErr("ns1.example.com", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, len(rrs), 1)
+	st.Expect(t, rrs[0].Authoritative, false) // glue from the referral
+}
+
+func TestPreferAuthoritative(t *testing.T) {
+	r := NewResolver()
+	r.cache.add("ns1.example.com.", RR{Name: "ns1.example.com.", Type: "A", Value: "192.0.2.99"})
+	r.cache.add("ns1.example.com.", RR{Name: "ns1.example.com.", Type: "A", Value: "192.0.2.53", Authoritative: true})
+	r.cache.add("ns1.example.com.", RR{Name: "ns1.example.com.", Type: "AAAA", Value: "2001:db8::53"})
+	rrs, err := r.cacheLookup("ns1.example.com.", "A")
+	st.Expect(t, err, nil)
+	st.Expect(t, rrs, RRs{{Name: "ns1.example.com.", Type: "A", Value: "192.0.2.53", Authoritative: true}})
+	rrs, err = r.cacheLookup("ns1.example.com.", "")
+	st.Expect(t, err, nil)
+	st.Expect(t, len(rrs), 2)
+	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Value == "192.0.2.99" }), 0)
+}
+
 func TestGoogleA(t *testing.T) {
 	r := NewResolver()
 	rrs, err := r.ResolveErr("google.com", "A")
@@ -148,6 +2147,26 @@ func TestGoogleMX(t *testing.T) {
 	st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "MX" }) >= 1, true)
 }
 
+func TestJabberSRV(t *testing.T) {
+	r := NewResolver()
+	rrs, err := r.ResolveErr("_xmpp-server._tcp.jabber.org", "SRV")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool {
+		_, _, port, target, ok := rr.SRV()
+		return ok && port != 0 && target != ""
+	}) >= 1, true)
+}
+
+func TestGoogleCAA(t *testing.T) {
+	r := NewResolver()
+	rrs, err := r.ResolveErr("google.com", "CAA")
+	st.Expect(t, err, nil)
+	st.Expect(t, count(rrs, func(rr RR) bool {
+		_, tag, value := rr.CAA()
+		return tag == "issue" && value == "pki.goog"
+	}), 1)
+}
+
 func TestGoogleAny(t *testing.T) {
 	time.Sleep(Timeout) // To address flaky test on GitHub Actions
 	r := NewResolver()
@@ -272,6 +2291,24 @@ func TestTTL(t *testing.T) {
 	st.Expect(t, rr.Expiry.IsZero(), false)
 }
 
+func TestServerTTL(t *testing.T) {
+	for _, options := range [][]Option{nil, {WithExpiry()}} {
+		r, _ := newTestResolver(t, testZoneRecords, options...)
+		rrs, err := r.ResolveErr("example.com", "A")
+		st.Expect(t, err, nil)
+		for _, rr := range rrs {
+			switch rr.Type {
+			case "A":
+				st.Expect(t, rr.TTL, 300*time.Second)
+			case "NS":
+				st.Expect(t, rr.TTL, 172800*time.Second)
+			}
+		}
+		st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" }), 1)
+		st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "NS" }), 2)
+	}
+}
+
 func checkTXT(t *testing.T, domain string) {
 	r := NewResolver(WithTCPRetry())
 	rrs, err := r.ResolveErr(domain, "TXT")
@@ -350,3 +2387,61 @@ func all(rrs RRs, f func(RR) bool) (out bool) {
 	}
 	return true
 }
+
+func BenchmarkResolveErrCacheHit(b *testing.B) {
+	r := NewResolver()
+	r.cache.add("example.com.", RR{Name: "example.com.", Type: "A", Value: "203.0.113.1"})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.ResolveErr("example.com", "A")
+	}
+}
+
+func BenchmarkWithMinimalResponse(b *testing.B) {
+	s := newTestServer(b, newTestZone(b, testZoneRecords...))
+	names := []string{"example.com", "www.example.com", "ns1.example.com", "nonexistent.example.com"}
+	for _, bm := range []struct {
+		name    string
+		options []Option
+	}{
+		{"Normal", nil},
+		{"Minimal", []Option{WithMinimalResponse()}},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				r := NewResolver(append([]Option{WithDialer(s.Dialer())}, bm.options...)...)
+				for _, name := range names {
+					r.ResolveErr(name, "A")
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkResolveEviction(b *testing.B) {
+	s := newTestServer(b, newTestZone(b, testZoneRecords...))
+	d := s.Dialer()
+	r := NewResolver(WithDialer(d), WithCache(20))
+	var failures int
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := r.ResolveErr(fmt.Sprintf("n%d.example.com", i), "A"); err != NXDOMAIN {
+			failures++
+		}
+	}
+	b.ReportMetric(float64(len(d.Dials()))/float64(b.N), "exchanges/op")
+	b.ReportMetric(float64(failures)/float64(b.N), "failures/op")
+}
+
+func BenchmarkResolveCached(b *testing.B) {
+	r := NewResolver()
+	r.cache.add("example.com.", RR{Name: "example.com.", Type: "A", Value: "203.0.113.1"})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.ResolveCached("example.com", "A")
+	}
+}
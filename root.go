@@ -1,6 +1,11 @@
 package dnsr
 
 import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"slices"
 	"strings"
 
 	_ "embed"
@@ -16,17 +21,84 @@ var root string
 var rootCache *cache
 
 func init() {
-	rootCache = newCache(strings.Count(root, "\n"), false)
-	zp := dns.NewZoneParser(strings.NewReader(root), "", "")
+	var err error
+	rootCache, err = parseRootHints(strings.NewReader(root))
+	if err != nil {
+		panic(err)
+	}
+}
+
+// parseRootHints parses root hints in the BIND named.root format into a cache.
+// Blank lines and comments beginning with ";" or "#" are ignored.
+// The hints must include at least one root NS record.
+// Parse errors identify the offending line.
+func parseRootHints(r io.Reader) (*cache, error) {
+	// Replace lines with "#" comments, which the zone parser doesn’t accept,
+	// with blank lines to preserve line numbers in errors
+	var b strings.Builder
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if !strings.HasPrefix(strings.TrimSpace(line), "#") {
+			b.WriteString(line)
+		}
+		b.WriteByte('\n')
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("root hints: %w", err)
+	}
 
+	var rrs RRs
+	zp := dns.NewZoneParser(strings.NewReader(b.String()), ".", "named.root")
 	for drr, ok := zp.Next(); ok; drr, ok = zp.Next() {
 		rr, ok := convertRR(drr, false)
 		if ok {
-			rootCache.add(rr.Name, rr)
+			rrs = append(rrs, rr)
 		}
 	}
-
 	if err := zp.Err(); err != nil {
-		panic(err)
+		return nil, fmt.Errorf("root hints: %w", err)
+	}
+	if !slices.ContainsFunc(rrs, func(rr RR) bool { return rr.Name == "." && rr.Type == "NS" }) {
+		return nil, fmt.Errorf("root hints: no root NS records")
+	}
+
+	c := newCache(len(rrs), false)
+	for _, rr := range rrs {
+		c.add(rr.Name, rr)
+	}
+	return c, nil
+}
+
+// RootHints returns a snapshot of the embedded root hints used by default
+// to find the root name servers, sorted by name, type, and value.
+func RootHints() RRs {
+	var rrs RRs
+	rootCache.rangeEntries(func(_ string, hints RRs) bool {
+		rrs = append(rrs, hints...)
+		return true
+	})
+	slices.SortFunc(rrs, compareRRs)
+	return rrs
+}
+
+// newRootServersCache returns a root cache with name server and address
+// records for the root name server IP addresses in addrs.
+// Invalid addresses are ignored.
+func newRootServersCache(addrs []string) *cache {
+	c := newCache(len(addrs)+1, false)
+	for i, addr := range addrs {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			continue
+		}
+		host := fmt.Sprintf("root%d.invalid.", i)
+		c.add(".", RR{Name: ".", Type: "NS", Value: host})
+		if ip.To4() != nil {
+			c.add(host, RR{Name: host, Type: "A", Value: ip.String()})
+		} else {
+			c.add(host, RR{Name: host, Type: "AAAA", Value: ip.String()})
+		}
 	}
+	return c
 }
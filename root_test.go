@@ -0,0 +1,68 @@
+package dnsr
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/nbio/st"
+)
+
+func TestParseRootHints(t *testing.T) {
+	tests := []struct {
+		name  string
+		hints string
+		ns    int
+		addrs int
+	}{
+		{"embedded", root, 13, 26},
+		{"continuation lines", `
+; continuation lines inherit the owner name
+.                        3600000  IN  NS    A.ROOT-SERVERS.NET.
+                         3600000  IN  NS    B.ROOT-SERVERS.NET.
+A.ROOT-SERVERS.NET.      3600000      A     198.41.0.4
+                         3600000      AAAA  2001:503:ba3e::2:30
+B.ROOT-SERVERS.NET.      3600000      A     170.247.170.2
+`, 2, 3},
+		{"hash comments and blank lines", `
+# Root hints for a private root
+
+    # indented comment
+.	3600000	NS	ns.private-root.example.
+
+ns.private-root.example.	3600000	A	192.0.2.1   ; trailing comment
+`, 1, 1},
+		{"IPv6 glue only", `
+$TTL 3600000
+. NS k.root-servers.net.
+k.root-servers.net. AAAA 2001:7fd::1
+`, 1, 1},
+		{"relative names", `
+$ORIGIN root-servers.net.
+.	3600000	NS	a
+a	3600000	A	198.41.0.4
+`, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseRootHints(strings.NewReader(tt.hints))
+			st.Assert(t, err, nil)
+			var rrs RRs
+			c.rangeEntries(func(_ string, hints RRs) bool {
+				rrs = append(rrs, hints...)
+				return true
+			})
+			st.Expect(t, count(rrs, func(rr RR) bool { return rr.Name == "." && rr.Type == "NS" }), tt.ns)
+			st.Expect(t, count(rrs, func(rr RR) bool { return rr.Type == "A" || rr.Type == "AAAA" }), tt.addrs)
+		})
+	}
+}
+
+func TestParseRootHintsErrors(t *testing.T) {
+	_, err := parseRootHints(strings.NewReader(". 3600000 NS a.root-servers.net.\na.root-servers.net. 3600000 A 198.41.0\n"))
+	st.Assert(t, err != nil, true)
+	st.Expect(t, strings.Contains(err.Error(), "line: 2"), true)
+
+	_, err = parseRootHints(strings.NewReader("; no records\n\n"))
+	st.Assert(t, err != nil, true)
+	st.Expect(t, strings.Contains(err.Error(), "no root NS records"), true)
+}
@@ -2,7 +2,10 @@ package dnsr
 
 import (
 	"fmt"
+	"io"
+	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/miekg/dns"
@@ -10,11 +13,15 @@ import (
 
 // RR represents a DNS resource record.
 type RR struct {
-	Name   string
+	Name   string // lowercase and fully qualified, e.g. "example.com.", unless returned as queried
 	Type   string
-	Value  string
-	TTL    time.Duration
-	Expiry time.Time
+	Value  string        // for TXT records, the record’s strings concatenated without a separator
+	TTL    time.Duration // TTL sent by the name server
+	Expiry time.Time     // zero unless the Resolver expires records
+
+	// Authoritative is true if the record was received in an authoritative
+	// answer, rather than a referral or as glue.
+	Authoritative bool
 }
 
 // RRs represents a slice of DNS resource records.
@@ -33,8 +40,9 @@ var emptyRRs = RRs{}
 const NameCollision = "127.0.53.53"
 
 // String returns a string representation of an RR in zone-file format.
+// Records with neither a TTL nor an expiry are shown with a TTL of 3600.
 func (rr *RR) String() string {
-	if rr.Expiry.IsZero() {
+	if rr.TTL == 0 && rr.Expiry.IsZero() {
 		return rr.Name + "\t      3600\tIN\t" + rr.Type + "\t" + rr.Value
 	} else {
 		ttl := ttlString(rr.TTL)
@@ -42,6 +50,114 @@ func (rr *RR) String() string {
 	}
 }
 
+// WriteZone writes rrs to w as zone-file lines, with name, TTL, class, type,
+// and value aligned in columns. Values are written in DNS presentation format,
+// e.g. with TXT and CAA values quoted. The TTL of expiring records is the time
+// remaining until expiry. Records with neither a TTL nor an expiry are
+// written with a TTL of 3600, as with String.
+func (rrs RRs) WriteZone(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 1, ' ', 0)
+	for i := range rrs {
+		rr := &rrs[i]
+		fmt.Fprintf(tw, "%s\t%d\tIN\t%s\t%s\n", rr.Name, rr.zoneTTL(), rr.Type, rr.rdata())
+	}
+	return tw.Flush()
+}
+
+// rdata returns the value of rr in DNS presentation format, without tabs.
+// Values that can’t be converted, such as the name server of an SOA record,
+// are returned as is.
+func (rr *RR) rdata() string {
+	if drr, ok := rr.dnsRR(); ok {
+		if s, ok := strings.CutPrefix(drr.String(), drr.Header().String()); ok {
+			return s
+		}
+	}
+	return strings.ReplaceAll(rr.Value, "\t", " ")
+}
+
+// SRV parses an SRV record, returning its target lowercase and fully
+// qualified. It reports false if rr is not a valid SRV record.
+func (rr *RR) SRV() (priority, weight, port uint16, target string, ok bool) {
+	if rr.Type != "SRV" {
+		return 0, 0, 0, "", false
+	}
+	f := strings.Split(rr.Value, "\t")
+	if len(f) != 4 {
+		return 0, 0, 0, "", false
+	}
+	p, err1 := strconv.ParseUint(f[0], 10, 16)
+	w, err2 := strconv.ParseUint(f[1], 10, 16)
+	n, err3 := strconv.ParseUint(f[2], 10, 16)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return 0, 0, 0, "", false
+	}
+	return uint16(p), uint16(w), uint16(n), toLowerFQDN(f[3]), true
+}
+
+// CAA parses a CAA record, returning its tag lowercase, e.g. "issue",
+// and its value unquoted, e.g. "letsencrypt.org".
+// The tag is empty if rr is not a valid CAA record.
+func (rr *RR) CAA() (flags uint8, tag, value string) {
+	if rr.Type != "CAA" {
+		return 0, "", ""
+	}
+	f := strings.SplitN(rr.Value, "\t", 3)
+	if len(f) != 3 || f[1] == "" {
+		return 0, "", ""
+	}
+	n, err := strconv.ParseUint(f[0], 10, 8)
+	if err != nil {
+		return 0, "", ""
+	}
+	return uint8(n), f[1], f[2]
+}
+
+// zoneTTL returns the TTL of rr in seconds for WriteZone.
+func (rr *RR) zoneTTL() int64 {
+	switch {
+	case !rr.Expiry.IsZero():
+		return int64(max(time.Until(rr.Expiry).Round(time.Second), 0) / time.Second)
+	case rr.TTL == 0:
+		return 3600
+	default:
+		return int64(rr.TTL / time.Second)
+	}
+}
+
+// MinTTL returns the smallest non-zero TTL of the records in rrs,
+// or 0 if none have a TTL.
+func (rrs RRs) MinTTL() time.Duration {
+	var ttl time.Duration
+	for _, rr := range rrs {
+		if rr.TTL > 0 && (ttl == 0 || rr.TTL < ttl) {
+			ttl = rr.TTL
+		}
+	}
+	return ttl
+}
+
+// GroupByType returns the records in rrs grouped by type,
+// preserving their order within each group.
+func (rrs RRs) GroupByType() map[string]RRs {
+	groups := make(map[string]RRs)
+	for _, rr := range rrs {
+		groups[rr.Type] = append(groups[rr.Type], rr)
+	}
+	return groups
+}
+
+// compareRRs orders records by name, type, and value.
+func compareRRs(a, b RR) int {
+	if c := strings.Compare(a.Name, b.Name); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.Type, b.Type); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Value, b.Value)
+}
+
 // ttlString constructs the TTL field of an RR string.
 func ttlString(ttl time.Duration) string {
 	seconds := int(ttl.Seconds())
@@ -49,40 +165,44 @@ func ttlString(ttl time.Duration) string {
 }
 
 // convertRR converts a dns.RR to an RR.
+// The RR TTL is the TTL sent by the name server. If expire is true,
+// the RR expiry is calculated from the TTL.
 // If the RR is not a type that this package uses,
 // It will attempt to translate this if there are enough parameters
 // Should all translation fail, it returns an undefined RR and false.
 func convertRR(drr dns.RR, expire bool) (RR, bool) {
-	var ttl time.Duration
+	ttl := time.Second * time.Duration(drr.Header().Ttl)
 	var expiry time.Time
 	if expire {
-		ttl, expiry = calculateExpiry(drr)
+		expiry = time.Now().Add(ttl)
 	}
+	rr := RR{Name: toLowerFQDN(drr.Header().Name), TTL: ttl, Expiry: expiry}
 	switch t := drr.(type) {
+	case *dns.OPT:
+		// An EDNS pseudo-record, not data
+		return RR{}, false
 	case *dns.SOA:
-		return RR{toLowerFQDN(t.Hdr.Name), "SOA", toLowerFQDN(t.Ns), ttl, expiry}, true
+		rr.Type, rr.Value = "SOA", toLowerFQDN(t.Ns)
 	case *dns.NS:
-		return RR{toLowerFQDN(t.Hdr.Name), "NS", toLowerFQDN(t.Ns), ttl, expiry}, true
+		rr.Type, rr.Value = "NS", toLowerFQDN(t.Ns)
 	case *dns.CNAME:
-		return RR{toLowerFQDN(t.Hdr.Name), "CNAME", toLowerFQDN(t.Target), ttl, expiry}, true
+		rr.Type, rr.Value = "CNAME", toLowerFQDN(t.Target)
 	case *dns.A:
-		return RR{toLowerFQDN(t.Hdr.Name), "A", t.A.String(), ttl, expiry}, true
+		rr.Type, rr.Value = "A", t.A.String()
 	case *dns.AAAA:
-		return RR{toLowerFQDN(t.Hdr.Name), "AAAA", t.AAAA.String(), ttl, expiry}, true
+		rr.Type, rr.Value = "AAAA", t.AAAA.String()
 	case *dns.TXT:
-		return RR{toLowerFQDN(t.Hdr.Name), "TXT", strings.Join(t.Txt, "\t"), ttl, expiry}, true
+		rr.Type, rr.Value = "TXT", strings.Join(t.Txt, "")
+	case *dns.SRV:
+		rr.Type, rr.Value = "SRV", fmt.Sprintf("%d\t%d\t%d\t%s", t.Priority, t.Weight, t.Port, toLowerFQDN(t.Target))
+	case *dns.CAA:
+		rr.Type, rr.Value = "CAA", fmt.Sprintf("%d\t%s\t%s", t.Flag, strings.ToLower(t.Tag), t.Value)
 	default:
 		fields := strings.Fields(drr.String())
-		if len(fields) >= 4 {
-			return RR{toLowerFQDN(fields[0]), fields[3], strings.Join(fields[4:], "\t"), ttl, expiry}, true
+		if len(fields) < 4 {
+			return RR{}, false
 		}
+		rr.Name, rr.Type, rr.Value = toLowerFQDN(fields[0]), fields[3], strings.Join(fields[4:], "\t")
 	}
-	return RR{}, false
-}
-
-// calculateExpiry calculates the expiry time of an RR.
-func calculateExpiry(drr dns.RR) (time.Duration, time.Time) {
-	ttl := time.Second * time.Duration(drr.Header().Ttl)
-	expiry := time.Now().Add(ttl)
-	return ttl, expiry
+	return rr, true
 }
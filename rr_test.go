@@ -1,9 +1,12 @@
 package dnsr
 
 import (
+	"slices"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/miekg/dns"
 	"github.com/nbio/st"
 )
 
@@ -29,3 +32,158 @@ func TestRRStringExpiring(t *testing.T) {
 	result := rr.String()
 	st.Expect(t, result, "example.com.	     86400	IN	A	203.0.113.1")
 }
+
+func TestRRStringTTL(t *testing.T) {
+	rr := RR{
+		Name:  "example.com.",
+		Type:  "A",
+		Value: "203.0.113.1",
+		TTL:   300 * time.Second,
+	}
+	result := rr.String()
+	st.Expect(t, result, "example.com.	       300	IN	A	203.0.113.1")
+}
+
+func TestConvertRRTTL(t *testing.T) {
+	drr, err := dns.NewRR("example.com. 300 IN A 203.0.113.1")
+	st.Assert(t, err, nil)
+	rr, ok := convertRR(drr, false)
+	st.Expect(t, ok, true)
+	st.Expect(t, rr.TTL, 300*time.Second)
+	st.Expect(t, rr.Expiry.IsZero(), true)
+	rr, ok = convertRR(drr, true)
+	st.Expect(t, ok, true)
+	st.Expect(t, rr.TTL, 300*time.Second)
+	st.Expect(t, rr.Expiry.After(time.Now().Add(299*time.Second)), true)
+}
+
+func TestConvertRRTXT(t *testing.T) {
+	var drr dns.RR = &dns.TXT{
+		Hdr: dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 300},
+		Txt: []string{"v=DKIM1; k=rsa; ", "p=MIGf\tMA0"},
+	}
+	rr, ok := convertRR(drr, false)
+	st.Expect(t, ok, true)
+	st.Expect(t, rr.Value, "v=DKIM1; k=rsa; p=MIGf\tMA0")
+
+	long := strings.Repeat("x", 300)
+	drr, ok = (&RR{Name: "example.com.", Type: "TXT", Value: long}).dnsRR()
+	st.Expect(t, ok, true)
+	st.Expect(t, drr.(*dns.TXT).Txt, []string{long[:255], long[255:]})
+	drr, ok = (&RR{Name: "example.com.", Type: "TXT"}).dnsRR()
+	st.Expect(t, ok, true)
+	st.Expect(t, drr.(*dns.TXT).Txt, []string{""})
+}
+
+func TestRRSRV(t *testing.T) {
+	drr, err := dns.NewRR("_xmpp-server._tcp.example.com. 300 IN SRV 5 10 5269 XMPP.example.com.")
+	st.Assert(t, err, nil)
+	rr, ok := convertRR(drr, false)
+	st.Expect(t, ok, true)
+	st.Expect(t, rr.Value, "5\t10\t5269\txmpp.example.com.")
+	priority, weight, port, target, ok := rr.SRV()
+	st.Expect(t, ok, true)
+	st.Expect(t, priority, uint16(5))
+	st.Expect(t, weight, uint16(10))
+	st.Expect(t, port, uint16(5269))
+	st.Expect(t, target, "xmpp.example.com.")
+
+	_, _, _, _, ok = (&RR{Type: "MX", Value: "5\t10\t5269\txmpp.example.com."}).SRV()
+	st.Expect(t, ok, false)
+	_, _, _, _, ok = (&RR{Type: "SRV", Value: "5\t10\txmpp.example.com."}).SRV()
+	st.Expect(t, ok, false)
+	_, _, _, _, ok = (&RR{Type: "SRV", Value: "5\t10\t65536\txmpp.example.com."}).SRV()
+	st.Expect(t, ok, false)
+}
+
+func TestRRCAA(t *testing.T) {
+	drr, err := dns.NewRR(`example.com. 300 IN CAA 128 ISSUE "letsencrypt.org; validationmethods=dns-01"`)
+	st.Assert(t, err, nil)
+	rr, ok := convertRR(drr, false)
+	st.Expect(t, ok, true)
+	st.Expect(t, rr.Value, "128\tissue\tletsencrypt.org; validationmethods=dns-01")
+	flags, tag, value := rr.CAA()
+	st.Expect(t, flags, uint8(128))
+	st.Expect(t, tag, "issue")
+	st.Expect(t, value, "letsencrypt.org; validationmethods=dns-01")
+
+	flags, tag, value = (&RR{Type: "CAA", Value: "0\tissue\t"}).CAA()
+	st.Expect(t, flags, uint8(0))
+	st.Expect(t, tag, "issue")
+	st.Expect(t, value, "")
+	_, tag, _ = (&RR{Type: "TXT", Value: "0\tissue\tletsencrypt.org"}).CAA()
+	st.Expect(t, tag, "")
+	_, tag, _ = (&RR{Type: "CAA", Value: "256\tissue\tletsencrypt.org"}).CAA()
+	st.Expect(t, tag, "")
+	_, tag, _ = (&RR{Type: "CAA", Value: "0\t\tletsencrypt.org"}).CAA()
+	st.Expect(t, tag, "")
+}
+
+func TestRRsGroupByType(t *testing.T) {
+	rrs := RRs{
+		{Name: "example.com.", Type: "NS", Value: "ns1.example.com."},
+		{Name: "example.com.", Type: "A", Value: "203.0.113.2"},
+		{Name: "example.com.", Type: "MX", Value: "10 mail.example.com."},
+		{Name: "example.com.", Type: "A", Value: "203.0.113.1"},
+		{Name: "example.com.", Type: "NS", Value: "ns2.example.com."},
+	}
+	groups := rrs.GroupByType()
+	st.Expect(t, len(groups), 3)
+	st.Expect(t, groups["A"], RRs{rrs[1], rrs[3]})
+	st.Expect(t, groups["NS"], RRs{rrs[0], rrs[4]})
+	st.Expect(t, groups["MX"], RRs{rrs[2]})
+	st.Expect(t, len(RRs(nil).GroupByType()), 0)
+}
+
+func TestRRsMinTTL(t *testing.T) {
+	rrs := RRs{
+		{Name: "example.com.", Type: "A", Value: "203.0.113.1", TTL: 300 * time.Second},
+		{Name: "example.com.", Type: "A", Value: "203.0.113.2"},
+		{Name: "example.com.", Type: "A", Value: "203.0.113.3", TTL: 60 * time.Second},
+		{Name: "example.com.", Type: "A", Value: "203.0.113.4", TTL: 3600 * time.Second},
+	}
+	st.Expect(t, rrs.MinTTL(), 60*time.Second)
+	st.Expect(t, rrs[1:2].MinTTL(), time.Duration(0))
+	st.Expect(t, RRs(nil).MinTTL(), time.Duration(0))
+}
+
+func TestRRsWriteZone(t *testing.T) {
+	rrs := RRs{
+		{Name: "example.com.", Type: "SOA", Value: "ns1.example.com.", TTL: 3600 * time.Second},
+		{Name: "example.com.", Type: "A", Value: "203.0.113.1", TTL: 300 * time.Second},
+		{Name: "www.example.com.", Type: "CNAME", Value: "example.com.", TTL: 86400 * time.Second},
+		{Name: "ns1.example.com.", Type: "AAAA", Value: "2001:db8::53"},
+		{Name: "mail.example.com.", Type: "MX", Value: "10\tmx.example.com.", Expiry: time.Now().Add(60 * time.Second)},
+	}
+	var b strings.Builder
+	err := rrs.WriteZone(&b)
+	st.Expect(t, err, nil)
+	st.Expect(t, b.String(), `example.com.      3600  IN SOA   ns1.example.com.
+example.com.      300   IN A     203.0.113.1
+www.example.com.  86400 IN CNAME example.com.
+ns1.example.com.  3600  IN AAAA  2001:db8::53
+mail.example.com. 60    IN MX    10 mx.example.com.
+`)
+
+	long := strings.Repeat("x", 300)
+	rrs = RRs{
+		{Name: "example.com.", Type: "TXT", Value: "v=spf1 include:_spf.example.com ~all", TTL: 300 * time.Second},
+		{Name: "example.com.", Type: "TXT", Value: long, TTL: 300 * time.Second},
+		{Name: "example.com.", Type: "CAA", Value: "0\tissue\tletsencrypt.org; validationmethods=dns-01", TTL: 300 * time.Second},
+		{Name: "_sip._tcp.example.com.", Type: "SRV", Value: "10\t5\t5060\tsip.example.com.", TTL: 300 * time.Second},
+	}
+	b.Reset()
+	err = rrs.WriteZone(&b)
+	st.Expect(t, err, nil)
+	st.Expect(t, b.String(), `example.com.           300 IN TXT "v=spf1 include:_spf.example.com ~all"
+example.com.           300 IN TXT "`+long[:255]+`" "`+long[255:]+`"
+example.com.           300 IN CAA 0 issue "letsencrypt.org; validationmethods=dns-01"
+_sip._tcp.example.com. 300 IN SRV 10 5 5060 sip.example.com.
+`)
+	for _, line := range strings.Split(strings.TrimSpace(b.String()), "\n") {
+		drr, err := dns.NewRR(line)
+		st.Expect(t, err, nil)
+		rr, _ := convertRR(drr, false)
+		st.Expect(t, slices.ContainsFunc(rrs, func(want RR) bool { return want.Value == rr.Value }), true)
+	}
+}
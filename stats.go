@@ -0,0 +1,194 @@
+package dnsr
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Stats is a snapshot of statistics for a Resolver.
+type Stats struct {
+	// Depths is a histogram of the maximum recursion depth reached by resolutions:
+	// Depths[i] is the number of resolutions that reached depth i.
+	Depths []uint64
+
+	// Evictions is the number of cache entries evicted to make room for others.
+	Evictions uint64
+
+	// EvictionRate is the number of cache entries evicted per second,
+	// averaged over the last EvictionRateWindow.
+	// A high rate indicates the cache capacity is too small.
+	EvictionRate float64
+
+	// DroppedEvents is the number of events not sent because
+	// the channel specified with WithEventChannel was full.
+	DroppedEvents uint64
+}
+
+// Stats returns a snapshot of statistics for r.
+func (r *Resolver) Stats() Stats {
+	s := r.stats.snapshot()
+	s.Evictions, s.EvictionRate = r.cache.evictionCount()
+	s.DroppedEvents = r.events.droppedCount()
+	return s
+}
+
+// CacheStats describes the contents of a Resolver cache.
+type CacheStats struct {
+	Capacity    int // maximum number of names cached
+	Len         int // number of names cached
+	RecordCount int // number of records cached, including expired records not yet evicted
+	NXCount     int // number of names cached as nonexistent (NXDOMAIN)
+}
+
+// CacheStats returns a snapshot of the contents of the cache of r.
+func (r *Resolver) CacheStats() CacheStats {
+	return r.cache.stats()
+}
+
+// stats accumulates statistics for a Resolver.
+type stats struct {
+	mu     sync.Mutex
+	depths []uint64
+}
+
+func newStats() *stats {
+	return &stats{}
+}
+
+// addDepth records a resolution that reached depth.
+func (s *stats) addDepth(depth int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.depths) <= depth {
+		s.depths = append(s.depths, 0)
+	}
+	s.depths[depth]++
+}
+
+// snapshot returns a Stats with the accumulated statistics.
+func (s *stats) snapshot() Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return Stats{Depths: append([]uint64(nil), s.depths...)}
+}
+
+// EvictionRateWindow is the period over which Stats.EvictionRate is averaged.
+const EvictionRateWindow = evictionBuckets * time.Second
+
+const evictionBuckets = 60
+
+// evictionWindow counts evictions in one-second buckets over EvictionRateWindow.
+type evictionWindow struct {
+	counts [evictionBuckets]uint64
+	secs   [evictionBuckets]int64 // Unix time of each bucket
+}
+
+// add counts an eviction at now.
+func (w *evictionWindow) add(now time.Time) {
+	sec := now.Unix()
+	i := sec % evictionBuckets
+	if w.secs[i] != sec {
+		w.secs[i] = sec
+		w.counts[i] = 0
+	}
+	w.counts[i]++
+}
+
+// rate returns the number of evictions per second within the window ending at now.
+func (w *evictionWindow) rate(now time.Time) float64 {
+	sec := now.Unix()
+	var n uint64
+	for i, s := range w.secs {
+		if s <= sec && sec-s < evictionBuckets {
+			n += w.counts[i]
+		}
+	}
+	return float64(n) / EvictionRateWindow.Seconds()
+}
+
+// NSStat counts the outcomes of exchanges with a name server.
+type NSStat struct {
+	Successes uint64 // responses received
+	Failures  uint64 // exchanges that failed for reasons other than timeouts
+	Timeouts  uint64 // exchanges that timed out
+}
+
+// DefaultNameserverStats is the default number of name servers
+// tracked by WithNameserverStats.
+const DefaultNameserverStats = 1000
+
+// NameserverStats returns a snapshot of the counters for each name server IP
+// address, or nil unless the Resolver was created with WithNameserverStats.
+func (r *Resolver) NameserverStats() map[string]NSStat {
+	return r.nsStats.snapshot()
+}
+
+// nsStats tracks NSStats for up to max name servers,
+// discarding the least recently used.
+type nsStats struct {
+	mu  sync.Mutex
+	max int
+	lru *list.List // of *nsStatEntry, most recently used first
+	m   map[string]*list.Element
+}
+
+type nsStatEntry struct {
+	ip   string
+	stat NSStat
+}
+
+func newNSStats(max int) *nsStats {
+	if max <= 0 {
+		max = DefaultNameserverStats
+	}
+	return &nsStats{max: max, lru: list.New(), m: make(map[string]*list.Element)}
+}
+
+// record records the outcome err of an exchange with the name server at ip.
+// Exchanges canceled because another name server answered are not recorded.
+// A nil nsStats records nothing.
+func (s *nsStats) record(ip string, err error) {
+	if s == nil || errors.Is(err, context.Canceled) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.m[ip]
+	if ok {
+		s.lru.MoveToFront(el)
+	} else {
+		if s.lru.Len() >= s.max {
+			oldest := s.lru.Back()
+			s.lru.Remove(oldest)
+			delete(s.m, oldest.Value.(*nsStatEntry).ip)
+		}
+		el = s.lru.PushFront(&nsStatEntry{ip: ip})
+		s.m[ip] = el
+	}
+	stat := &el.Value.(*nsStatEntry).stat
+	switch {
+	case err == nil:
+		stat.Successes++
+	case isTimeout(err):
+		stat.Timeouts++
+	default:
+		stat.Failures++
+	}
+}
+
+// snapshot returns a copy of the counters, or nil for a nil nsStats.
+func (s *nsStats) snapshot() map[string]NSStat {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m := make(map[string]NSStat, len(s.m))
+	for ip, el := range s.m {
+		m[ip] = el.Value.(*nsStatEntry).stat
+	}
+	return m
+}
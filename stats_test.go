@@ -0,0 +1,102 @@
+package dnsr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbio/st"
+)
+
+func TestStatsDepths(t *testing.T) {
+	r, _ := newTestResolver(t, testZoneRecords)
+	st.Expect(t, len(r.Stats().Depths), 0)
+	_, err := r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	_, err = r.ResolveErr("example.com", "A")
+	st.Expect(t, err, nil)
+	depths := r.Stats().Depths
+	st.Expect(t, len(depths) > 2, true)
+	st.Expect(t, depths[1], uint64(1))
+	var n uint64
+	for _, c := range depths {
+		n += c
+	}
+	st.Expect(t, n, uint64(2))
+
+	// Snapshots are copies
+	depths[1] = 100
+	st.Expect(t, r.Stats().Depths[1], uint64(1))
+}
+
+func TestStatsEvictions(t *testing.T) {
+	r := NewResolver(WithCache(2))
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("%d.example.com.", i)
+		r.cache.add(name, RR{Name: name, Type: "A", Value: "192.0.2.1"})
+	}
+	s := r.Stats()
+	st.Expect(t, s.Evictions, uint64(8))
+	st.Expect(t, s.EvictionRate, 8/EvictionRateWindow.Seconds())
+	// Reading Stats doesn’t reset the rate
+	s = r.Stats()
+	st.Expect(t, s.Evictions, uint64(8))
+	st.Expect(t, s.EvictionRate, 8/EvictionRateWindow.Seconds())
+}
+
+func TestEvictionWindow(t *testing.T) {
+	var w evictionWindow
+	now := time.Unix(1000, 0)
+	w.add(now)
+	w.add(now.Add(30 * time.Second))
+	w.add(now.Add(30 * time.Second))
+	st.Expect(t, w.rate(now.Add(30*time.Second)), 3/EvictionRateWindow.Seconds())
+	st.Expect(t, w.rate(now.Add(61*time.Second)), 2/EvictionRateWindow.Seconds())
+	st.Expect(t, w.rate(now.Add(91*time.Second)), float64(0))
+	w.add(now.Add(120 * time.Second)) // reuses the first bucket
+	st.Expect(t, w.rate(now.Add(120*time.Second)), 1/EvictionRateWindow.Seconds())
+}
+
+func TestCacheStats(t *testing.T) {
+	r := NewResolver(WithCache(10))
+	st.Expect(t, r.CacheStats(), CacheStats{Capacity: 10})
+	r.cache.add("example.com.", RR{Name: "example.com.", Type: "A", Value: "192.0.2.1"})
+	r.cache.add("example.com.", RR{Name: "example.com.", Type: "AAAA", Value: "2001:db8::1"})
+	r.cache.addNoData("www.example.com.", "A", time.Time{})
+	r.cache.addNX("nx.example.com.", time.Time{})
+	st.Expect(t, r.CacheStats(), CacheStats{Capacity: 10, Len: 3, RecordCount: 2, NXCount: 1})
+}
+
+func TestNameserverStats(t *testing.T) {
+	st.Expect(t, NewResolver().NameserverStats(), map[string]NSStat(nil))
+
+	r, d := newTestResolver(t, testZoneRecords, WithNameserverStats(0), WithExpiry())
+	d.Unreachable = func(addr string) bool {
+		return strings.HasPrefix(addr, "192.0.2.53:")
+	}
+	for i := 0; i < 10; i++ { // name servers are queried in random order
+		expireCache(r, time.Now().Add(-time.Hour))
+		_, err := r.ResolveErr("example.com", "A")
+		st.Expect(t, err, nil)
+	}
+	ns := r.NameserverStats()
+	st.Expect(t, ns["192.0.2.53"].Successes, uint64(0))
+	st.Expect(t, ns["192.0.2.54"].Failures, uint64(0))
+	st.Expect(t, ns["192.0.2.53"].Failures+ns["192.0.2.54"].Successes > 0, true)
+}
+
+func TestNSStatsLRU(t *testing.T) {
+	s := newNSStats(2)
+	s.record("192.0.2.1", nil)
+	s.record("192.0.2.2", errors.New("refused"))
+	s.record("192.0.2.1", ErrTimeout)
+	s.record("192.0.2.3", nil) // evicts 192.0.2.2
+	s.record("192.0.2.3", context.Canceled)
+	st.Expect(t, s.snapshot(), map[string]NSStat{
+		"192.0.2.1": {Successes: 1, Timeouts: 1},
+		"192.0.2.3": {Successes: 1},
+	})
+}
@@ -14,6 +14,24 @@ func parent(name string) (string, bool) {
 	return toLowerFQDN(strings.Join(labels[1:], ".")), true
 }
 
+// validName reports whether name is a syntactically valid domain name
+// of at most 253 bytes, not counting a trailing dot, with no whitespace
+// or control characters. The root domain "." is valid.
+func validName(name string) bool {
+	if name == "" || len(strings.TrimSuffix(name, ".")) > 253 {
+		return false
+	}
+	if _, ok := dns.IsDomainName(name); !ok {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] <= ' ' || name[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
 func toLowerFQDN(name string) string {
 	return dns.Fqdn(strings.ToLower(name))
 }
@@ -1,6 +1,7 @@
 package dnsr
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/nbio/st"
@@ -11,3 +12,17 @@ func TestToLowerFQDN(t *testing.T) {
 	st.Expect(t, toLowerFQDN("boO.net"), "boo.net.")
 	st.Expect(t, toLowerFQDN("just.another.HORSE"), "just.another.horse.")
 }
+
+func TestValidName(t *testing.T) {
+	st.Expect(t, validName("example.com"), true)
+	st.Expect(t, validName("example.com."), true)
+	st.Expect(t, validName("_dmarc.example.com"), true)
+	st.Expect(t, validName("."), true)
+	st.Expect(t, validName(""), false)
+	st.Expect(t, validName("a..b"), false)
+	st.Expect(t, validName("exa mple.com"), false)
+	st.Expect(t, validName("example.com\x00"), false)
+	st.Expect(t, validName(strings.Repeat("a.", 126)+"a"), true)   // 253 bytes
+	st.Expect(t, validName(strings.Repeat("a.", 126)+"aa"), false) // 254 bytes
+	st.Expect(t, validName(strings.Repeat("a", 64)+".com"), false) // label too long
+}
@@ -0,0 +1,391 @@
+package dnsr
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testServer is a local DNS server listening on UDP and TCP for hermetic tests.
+type testServer struct {
+	Addr string
+	udp  *dns.Server
+	tcp  *dns.Server
+}
+
+// newTestServer starts a testServer with handler h on an ephemeral port.
+// The server is shut down when the test completes.
+func newTestServer(t testing.TB, h dns.Handler) *testServer {
+	t.Helper()
+	var pc net.PacketConn
+	var l net.Listener
+	var err error
+	for i := 0; i < 10; i++ {
+		pc, err = net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		l, err = net.Listen("tcp", pc.LocalAddr().String())
+		if err == nil {
+			break
+		}
+		pc.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{
+		Addr: pc.LocalAddr().String(),
+		udp:  &dns.Server{PacketConn: pc, Handler: h},
+		tcp:  &dns.Server{Listener: l, Handler: h},
+	}
+	for _, srv := range []*dns.Server{s.udp, s.tcp} {
+		var wg sync.WaitGroup
+		wg.Add(1)
+		srv.NotifyStartedFunc = wg.Done
+		go srv.ActivateAndServe()
+		wg.Wait()
+	}
+	t.Cleanup(func() {
+		s.udp.Shutdown()
+		s.tcp.Shutdown()
+	})
+	return s
+}
+
+// newTestTLSServer starts a DNS over TLS server with handler h on an ephemeral port,
+// with a self-signed certificate for serverName. It returns the server address
+// and a client configuration that trusts the certificate.
+// The server is shut down when the test completes.
+func newTestTLSServer(t testing.TB, h dns.Handler, serverName string) (string, *tls.Config) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: serverName},
+		DNSNames:     []string{serverName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{Listener: l, Net: "tcp-tls", Handler: h}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	srv.NotifyStartedFunc = wg.Done
+	go srv.ActivateAndServe()
+	wg.Wait()
+	t.Cleanup(func() { srv.Shutdown() })
+	return l.Addr().String(), &tls.Config{ServerName: serverName, RootCAs: pool}
+}
+
+// Dialer returns a testDialer that connects to s regardless of the requested address.
+func (s *testServer) Dialer() *testDialer {
+	return &testDialer{addr: s.Addr}
+}
+
+// testDialer is a ContextDialer that redirects all connections to addr,
+// recording the network and address of each dial.
+// If Unreachable is set, dials to addresses for which it returns true fail.
+// If Blackholed is set, dials to addresses for which it returns true
+// hang until the context is done.
+type testDialer struct {
+	addr        string
+	Unreachable func(addr string) bool
+	Blackholed  func(addr string) bool
+	mu          sync.Mutex
+	dials       []string
+}
+
+func (d *testDialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	d.mu.Lock()
+	d.dials = append(d.dials, network+" "+addr)
+	d.mu.Unlock()
+	if d.Unreachable != nil && d.Unreachable(addr) {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: errors.New("network is unreachable")}
+	}
+	if d.Blackholed != nil && d.Blackholed(addr) {
+		<-ctx.Done()
+		return nil, &net.OpError{Op: "dial", Net: network, Err: ctx.Err()}
+	}
+	var nd net.Dialer
+	return nd.DialContext(ctx, network, d.addr)
+}
+
+// Dials returns the network and address of each dial so far.
+func (d *testDialer) Dials() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.dials...)
+}
+
+// testSOCKSServer is a minimal SOCKS5 proxy supporting only CONNECT without authentication.
+// It connects to target regardless of the requested address, recording each requested address.
+type testSOCKSServer struct {
+	Addr   string
+	target string
+	mu     sync.Mutex
+	conns  []string
+}
+
+// newTestSOCKSServer starts a testSOCKSServer proxying to target on an ephemeral port.
+// The server is shut down when the test completes.
+func newTestSOCKSServer(t testing.TB, target string) *testSOCKSServer {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	s := &testSOCKSServer{Addr: l.Addr().String(), target: target}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *testSOCKSServer) serve(c net.Conn) {
+	defer c.Close()
+	buf := make([]byte, 256)
+	// Greeting: version, method count, methods
+	if _, err := io.ReadFull(c, buf[:2]); err != nil || buf[0] != 5 {
+		return
+	}
+	if _, err := io.ReadFull(c, buf[:buf[1]]); err != nil {
+		return
+	}
+	c.Write([]byte{5, 0}) // no authentication
+	// Request: version, command, reserved, address type, address, port
+	if _, err := io.ReadFull(c, buf[:4]); err != nil || buf[1] != 1 {
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 1, 4:
+		n := net.IPv4len
+		if buf[3] == 4 {
+			n = net.IPv6len
+		}
+		if _, err := io.ReadFull(c, buf[:n]); err != nil {
+			return
+		}
+		host = net.IP(buf[:n]).String()
+	case 3:
+		if _, err := io.ReadFull(c, buf[:1]); err != nil {
+			return
+		}
+		n := int(buf[0])
+		if _, err := io.ReadFull(c, buf[:n]); err != nil {
+			return
+		}
+		host = string(buf[:n])
+	default:
+		return
+	}
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		return
+	}
+	addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2]))))
+	s.mu.Lock()
+	s.conns = append(s.conns, addr)
+	s.mu.Unlock()
+	tc, err := net.Dial("tcp", s.target)
+	if err != nil {
+		c.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0}) // connection refused
+		return
+	}
+	defer tc.Close()
+	c.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(tc, c)
+	io.Copy(c, tc)
+}
+
+// Conns returns the addresses of each CONNECT request so far.
+func (s *testSOCKSServer) Conns() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.conns...)
+}
+
+// testZone is a dns.Handler that answers authoritatively for every name
+// from a set of records, as if it were every name server in the hierarchy.
+// Responses to NS queries include glue for the name servers.
+// Queries for names with no records at or below them receive NXDOMAIN,
+// and empty responses include the SOA of the closest enclosing zone, if any.
+// Names with NS records but no SOA record are delegations:
+// queries at or below them receive a referral to the delegated name servers.
+type testZone []dns.RR
+
+// newTestZone parses records in zone file format into a testZone.
+func newTestZone(t testing.TB, records ...string) testZone {
+	t.Helper()
+	z := make(testZone, 0, len(records))
+	for _, s := range records {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		z = append(z, rr)
+	}
+	return z
+}
+
+func (z testZone) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	w.WriteMsg(z.reply(req))
+}
+
+// reply returns the response to req.
+func (z testZone) reply(req *dns.Msg) *dns.Msg {
+	q := req.Question[0]
+	qname := strings.ToLower(q.Name)
+	m := new(dns.Msg)
+	m.SetReply(req)
+	if cut := z.cut(qname); cut != "" {
+		for _, rr := range z {
+			if ns, ok := rr.(*dns.NS); ok && strings.EqualFold(ns.Hdr.Name, cut) {
+				m.Ns = append(m.Ns, ns)
+				m.Extra = append(m.Extra, z.addrs(ns.Ns)...)
+			}
+		}
+		return m
+	}
+	m.Authoritative = true
+	exists := false
+	for _, rr := range z {
+		h := rr.Header()
+		if !dns.IsSubDomain(qname, strings.ToLower(h.Name)) {
+			continue
+		}
+		exists = true
+		if strings.EqualFold(h.Name, qname) && (h.Rrtype == q.Qtype || h.Rrtype == dns.TypeCNAME) {
+			m.Answer = append(m.Answer, rr)
+		}
+	}
+	for _, rr := range m.Answer {
+		if ns, ok := rr.(*dns.NS); ok {
+			m.Extra = append(m.Extra, z.addrs(ns.Ns)...)
+		}
+	}
+	if len(m.Answer) == 0 {
+		if !exists {
+			m.Rcode = dns.RcodeNameError
+		}
+		if soa := z.soa(qname); soa != nil {
+			m.Ns = append(m.Ns, soa)
+		}
+	}
+	return m
+}
+
+// cut returns the delegation enclosing name, or "" if name is in an authoritative zone.
+func (z testZone) cut(name string) string {
+	var cut string
+	labels := -1
+	for _, rr := range z {
+		h := rr.Header()
+		owner := strings.ToLower(h.Name)
+		if h.Rrtype == dns.TypeNS && dns.IsSubDomain(owner, name) && dns.CountLabel(owner) > labels {
+			cut = owner
+			labels = dns.CountLabel(owner)
+		}
+	}
+	for _, rr := range z {
+		h := rr.Header()
+		if h.Rrtype == dns.TypeSOA && strings.EqualFold(h.Name, cut) {
+			return ""
+		}
+	}
+	return cut
+}
+
+// addrs returns the A and AAAA records for name.
+func (z testZone) addrs(name string) []dns.RR {
+	var rrs []dns.RR
+	for _, rr := range z {
+		h := rr.Header()
+		if strings.EqualFold(h.Name, name) && (h.Rrtype == dns.TypeA || h.Rrtype == dns.TypeAAAA) {
+			rrs = append(rrs, rr)
+		}
+	}
+	return rrs
+}
+
+// soa returns the SOA record of the closest zone enclosing name, or nil.
+func (z testZone) soa(name string) dns.RR {
+	var soa dns.RR
+	for _, rr := range z {
+		h := rr.Header()
+		if h.Rrtype != dns.TypeSOA || !dns.IsSubDomain(strings.ToLower(h.Name), name) {
+			continue
+		}
+		if soa == nil || dns.CountLabel(h.Name) > dns.CountLabel(soa.Header().Name) {
+			soa = rr
+		}
+	}
+	return soa
+}
+
+// testZoneRecords is a minimal hierarchy for example.com.
+var testZoneRecords = []string{
+	"com. 172800 IN NS a.gtld-servers.net.",
+	"a.gtld-servers.net. 172800 IN A 192.0.2.1",
+	"com. 900 IN SOA a.gtld-servers.net. nstld.verisign-grs.com. 1 1800 900 604800 86400",
+	"example.com. 172800 IN NS ns1.example.com.",
+	"example.com. 172800 IN NS ns2.example.com.",
+	"ns1.example.com. 172800 IN A 192.0.2.53",
+	"ns2.example.com. 172800 IN A 192.0.2.54",
+	"example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 3600 1209600 300",
+	"example.com. 300 IN A 203.0.113.1",
+	"www.example.com. 300 IN CNAME example.com.",
+}
+
+// newTestResolver returns a Resolver that sends all queries to a testServer
+// answering from records, along with the dialer used.
+func newTestResolver(t testing.TB, records []string, options ...Option) (*Resolver, *testDialer) {
+	t.Helper()
+	s := newTestServer(t, newTestZone(t, records...))
+	d := s.Dialer()
+	return NewResolver(append([]Option{WithDialer(d)}, options...)...), d
+}